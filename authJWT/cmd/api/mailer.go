@@ -0,0 +1,21 @@
+package main
+
+import (
+	"todo_api/internal/config"
+	"todo_api/internal/mailer"
+)
+
+// newMailer picks the mailer named by MAILER.
+func newMailer(cfg *config.Config) mailer.Mailer {
+	if cfg.Mailer == "smtp" {
+		return &mailer.SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}
+	}
+
+	return &mailer.LogMailer{File: cfg.MailFile}
+}
@@ -1,17 +1,39 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"todo_api/internal/auth"
 	"todo_api/internal/config"
 	"todo_api/internal/database"
 	"todo_api/internal/handlers"
+	"todo_api/internal/keys"
+	"todo_api/internal/lockout"
+	"todo_api/internal/mailer"
 	"todo_api/internal/middleware"
+	"todo_api/internal/rbac"
+	"todo_api/internal/revocation"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // migrate create -ext sql -dir migrations -seq create_todos_table
+//
+// Migrations are embedded and applied on startup (unless
+// MIGRATE_ON_START=false); run "todo_api migrate <up|down|status|version|force>"
+// to manage them by hand. "todo_api roles <email> [role...]" replaces the
+// roles of a user, e.g. to make the first administrator.
+
+// shutdownTimeout bounds how long in-flight requests get to finish on
+// SIGTERM.
+const shutdownTimeout = 10 * time.Second
 
 func main() {
 	var cfg *config.Config
@@ -22,6 +44,24 @@ func main() {
 		log.Fatal("Failed to load configuration:", err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = database.Migrate(cfg.DatabaseURL, os.Args[2:], os.Stdout)
+
+		if err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+
+		return
+	}
+
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		err = database.Migrate(cfg.DatabaseURL, []string{"up"}, os.Stdout)
+
+		if err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+	}
+
 	var pool *pgxpool.Pool
 	pool, err = database.Connect(cfg.DatabaseURL)
 
@@ -31,6 +71,16 @@ func main() {
 
 	defer pool.Close()
 
+	if len(os.Args) > 1 && os.Args[1] == "roles" {
+		err = setRoles(pool, os.Args[2:])
+
+		if err != nil {
+			log.Fatal("Failed to set roles:", err)
+		}
+
+		return
+	}
+
 	var router *gin.Engine = gin.Default()
 	router.SetTrustedProxies(nil)
 	router.GET("/", func(c *gin.Context) {
@@ -43,11 +93,84 @@ func main() {
 		})
 	})
 
-	router.POST("/auth/register", handlers.CreateUserHandler(pool))
-	router.POST("/auth/login", handlers.LoginHandler(pool, cfg))
+	// signing keys: RS256/EdDSA from JWT_KEYS(_FILE), or HS256 with JWT_SECRET
+	keySet, err := keys.Load(cfg.JWTKeys, cfg.JWTKeysFile, cfg.JWTSecret)
+
+	if err != nil {
+		log.Fatal("Failed to load signing keys:", err)
+	}
+
+	var tokens *auth.TokenService = auth.NewTokenService(pool, cfg, keySet)
+
+	// revoked access tokens live in Postgres, cached so that the
+	// middleware does not query it on every request
+	var revocations *revocation.CachedStore = revocation.NewCachedStore(revocation.NewPostgresStore(pool), cfg.RevocationCacheTTL)
+
+	var requireAuth gin.HandlerFunc = middleware.AuthMiddleware(keySet, revocations, cfg.JWTIssuer, cfg.JWTAudience)
+
+	// permissions of the roles in a token, from role_permissions
+	var policy *rbac.Policy = rbac.NewPolicy(pool, cfg.RoleCacheTTL)
+
+	// public keys for services that verify our tokens
+	router.GET("/.well-known/jwks.json", handlers.JWKSHandler(keySet))
+
+	// failed logins per account and per address, in Postgres unless
+	// LOGIN_ATTEMPT_STORE=memory
+	var attempts lockout.Store = lockout.NewPostgresStore(pool)
+
+	if cfg.LoginAttemptStore == "memory" {
+		attempts = lockout.NewMemoryStore()
+	}
+
+	var limiter *lockout.Limiter = lockout.NewLimiter(attempts, lockout.Policy{
+		MaxAccountFailures: cfg.LoginMaxFailures,
+		MaxIPFailures:      cfg.LoginMaxIPFailures,
+		Window:             cfg.LoginFailureWindow,
+		LockoutDuration:    cfg.LoginLockoutDuration,
+		BaseDelay:          cfg.LoginDelayBase,
+		MaxDelay:           cfg.LoginDelayMax,
+	})
+
+	// verification links go out by SMTP, or to the log when testing locally
+	var mail mailer.Mailer = newMailer(cfg)
+	var verifier *auth.VerificationService = auth.NewVerificationService(pool, cfg, keySet, mail)
+	var passwords *auth.PasswordService = auth.NewPasswordService(pool, cfg, mail, tokens, revocations)
+
+	// reset and verification mails per email and per address, counted
+	// with failed logins
+	var resetThrottle *lockout.Throttle = lockout.NewThrottle(attempts, "reset", cfg.MailMaxPerEmail, cfg.MailMaxPerIP, cfg.MailRateWindow)
+	var verifyThrottle *lockout.Throttle = lockout.NewThrottle(attempts, "verify", cfg.MailMaxPerEmail, cfg.MailMaxPerIP, cfg.MailRateWindow)
+
+	// optional TOTP second factor
+	mfa, err := auth.NewMFAService(pool, cfg, keySet)
+
+	if err != nil {
+		log.Fatal("Failed to set up two-factor authentication:", err)
+	}
+
+	if cfg.MFAEncryptionKey == nil {
+		log.Println("Warning: MFA_ENCRYPTION_KEY is not set, two-factor authentication is unavailable")
+	}
+
+	router.POST("/auth/register", handlers.CreateUserHandler(pool, verifier))
+	router.POST("/auth/login", handlers.LoginHandler(pool, tokens, mfa, limiter, cfg.RequireEmailVerification))
+	router.POST("/auth/mfa/verify", handlers.MFAVerifyHandler(pool, tokens, mfa, limiter))
+	router.GET("/auth/verify", handlers.VerifyEmailHandler(verifier))
+	router.POST("/auth/verify", handlers.VerifyEmailHandler(verifier))
+	router.POST("/auth/resend-verification", handlers.ResendVerificationHandler(verifier, verifyThrottle))
+	router.POST("/auth/forgot-password", handlers.ForgotPasswordHandler(passwords, resetThrottle))
+	router.GET("/auth/reset-password", handlers.ResetPasswordPageHandler())
+	router.POST("/auth/reset-password", handlers.ResetPasswordHandler(passwords))
+	router.POST("/auth/change-password", requireAuth, handlers.ChangePasswordHandler(pool, passwords, limiter))
+	router.POST("/auth/mfa/enroll", requireAuth, handlers.MFAEnrollHandler(pool, mfa))
+	router.POST("/auth/mfa/confirm", requireAuth, handlers.MFAConfirmHandler(mfa))
+	router.POST("/auth/mfa/disable", requireAuth, handlers.MFADisableHandler(pool, mfa, limiter))
+	router.POST("/auth/refresh", handlers.RefreshHandler(tokens))
+	router.POST("/auth/logout", requireAuth, handlers.LogoutHandler(tokens, revocations))
+	router.POST("/auth/logout-all", requireAuth, handlers.LogoutAllHandler(tokens, revocations))
 
 	protected := router.Group("/todos")
-	protected.Use(middleware.AuthMiddleware(cfg))
+	protected.Use(requireAuth)
 	{
 		protected.POST("", handlers.CreateTodoHandler(pool))
 		protected.GET("", handlers.GetAllTodosHandler(pool))
@@ -56,8 +179,70 @@ func main() {
 		protected.DELETE("/:id", handlers.DeleteToDoHandler(pool))
 	}
 
+	// administrators act on other users and their todos
+	admin := router.Group("/admin")
+	admin.Use(requireAuth)
+	{
+		admin.GET("/users", middleware.RequirePermission(policy, rbac.PermissionUsersRead), handlers.ListUsersHandler(pool))
+		admin.PUT("/users/:user_id/roles", middleware.RequirePermission(policy, rbac.PermissionUserRolesWrite), handlers.SetUserRolesHandler(pool, revocations))
+		admin.POST("/users/:user_id/unlock", middleware.RequirePermission(policy, rbac.PermissionUsersUnlock), handlers.UnlockUserHandler(pool, limiter))
+
+		readTodos := middleware.RequirePermission(policy, rbac.PermissionTodosReadAny)
+		writeTodos := middleware.RequirePermission(policy, rbac.PermissionTodosWriteAny)
+
+		admin.GET("/users/:user_id/todos", readTodos, handlers.AdminGetAllTodosHandler(pool))
+		admin.GET("/users/:user_id/todos/:id", readTodos, handlers.AdminGetToDoByIDHandler(pool))
+		admin.PUT("/users/:user_id/todos/:id", writeTodos, handlers.AdminUpdateToDoHandler(pool))
+		admin.DELETE("/users/:user_id/todos/:id", writeTodos, handlers.AdminDeleteToDoHandler(pool))
+	}
+
 	// Middleware Test Route
-	router.GET("/protected-test", middleware.AuthMiddleware(cfg), handlers.TestProtectedHandler())
+	router.GET("/protected-test", requireAuth, handlers.TestProtectedHandler())
+
+	var server *http.Server = &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: router,
+	}
+
+	// stop on SIGINT/SIGTERM: stop accepting connections, let in-flight
+	// requests finish, then close the database pool (deferred above)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// forget revocations of tokens that have expired anyway
+	go revocation.RunCleanup(ctx, revocations, time.Hour)
+
+	// forget failed logins and mail requests that no longer count
+	var attemptsMaxAge time.Duration = cfg.LoginFailureWindow + cfg.LoginLockoutDuration
+
+	if cfg.MailRateWindow > attemptsMaxAge {
+		attemptsMaxAge = cfg.MailRateWindow
+	}
+
+	go lockout.RunCleanup(ctx, attempts, time.Hour, attemptsMaxAge)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Println("Server stopped:", err)
+		}
+	case <-ctx.Done():
+		log.Println("Shutting down server...")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Println("Server forced to shut down:", err)
+	}
 
-	router.Run(":" + cfg.Port)
+	log.Println("Server exited")
 }
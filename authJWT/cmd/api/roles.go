@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"todo_api/internal/repository"
+	"todo_api/internal/revocation"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// setRoles handles "todo_api roles <email> [role...]": it replaces the
+// roles of the user with that email and prints the result. Without roles
+// it only prints the current ones. Changing roles revokes the user's access
+// tokens, as the admin endpoint does, so the old roles claim stops working.
+func setRoles(pool *pgxpool.Pool, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: todo_api roles <email> [role...]")
+	}
+
+	user, err := repository.GetUserByEmail(pool, args[0])
+
+	if err != nil {
+		return fmt.Errorf("user %s: %w", args[0], err)
+	}
+
+	if len(args) > 1 {
+		err = repository.SetUserRoles(pool, user.ID, args[1:])
+
+		if err != nil {
+			return err
+		}
+
+		err = revocation.NewPostgresStore(pool).RevokeUser(user.ID)
+
+		if err != nil {
+			return fmt.Errorf("roles changed, but revoking access tokens failed: %w", err)
+		}
+	}
+
+	roles, err := repository.GetUserRoles(pool, user.ID)
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s has roles %v", user.Email, roles)
+
+	return nil
+}
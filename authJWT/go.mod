@@ -44,3 +44,7 @@ require (
 	golang.org/x/text v0.31.0 // indirect
 	google.golang.org/protobuf v1.36.10 // indirect
 )
+
+require dbmigrate v0.0.0-00010101000000-000000000000
+
+replace dbmigrate => ../dbmigrate
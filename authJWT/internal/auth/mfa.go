@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+	"todo_api/internal/config"
+	"todo_api/internal/keys"
+	"todo_api/internal/models"
+	"todo_api/internal/repository"
+	"todo_api/internal/totp"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrMFANotEnrolled means a code was confirmed before enrolling.
+	ErrMFANotEnrolled = errors.New("two-factor authentication is not enrolled")
+	ErrMFANotEnabled  = errors.New("two-factor authentication is not enabled")
+	// ErrInvalidMFACode means the code is wrong, already used or, for a
+	// recovery code, unknown.
+	ErrInvalidMFACode = errors.New("invalid two-factor code")
+	// ErrInvalidMFAChallenge means the challenge token is malformed,
+	// expired, already used or superseded by a newer login.
+	ErrInvalidMFAChallenge = errors.New("invalid MFA challenge")
+)
+
+// recoveryCodeCount is how many recovery codes confirming enrollment
+// hands out.
+const recoveryCodeCount = 10
+
+// MFAService manages TOTP two-factor authentication.
+//
+// A user enrolls to get a secret, then confirms a code from it to enable
+// two-factor login and receive recovery codes. Logging in with a password
+// then only yields a challenge token, a single-use signed token like the
+// email verification one, which is exchanged for a token pair together
+// with a TOTP or recovery code. Secrets are stored encrypted with
+// cfg.MFAEncryptionKey; without it every method but Challenge fails with
+// ErrMFAUnavailable.
+type MFAService struct {
+	pool *pgxpool.Pool
+	cfg  *config.Config
+	keys *keys.KeySet
+	aead cipher.AEAD
+}
+
+func NewMFAService(pool *pgxpool.Pool, cfg *config.Config, keySet *keys.KeySet) (*MFAService, error) {
+	aead, err := newSecretCipher(cfg.MFAEncryptionKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAService{pool: pool, cfg: cfg, keys: keySet, aead: aead}, nil
+}
+
+// Enrollment is what an authenticator app needs: the otpauth URI, usually
+// shown as a QR code, or the secret to type in.
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// Enroll gives user a new secret, replacing any unconfirmed one.
+func (s *MFAService) Enroll(user *models.User) (*Enrollment, error) {
+	secret, err := totp.GenerateSecret()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := s.sealSecret(user.ID, secret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := repository.SetPendingTOTPSecret(s.pool, user.ID, sealed)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	return &Enrollment{
+		Secret: secret,
+		URI:    totp.URI(s.cfg.MFAIssuer, user.Email, secret),
+	}, nil
+}
+
+// Confirm enables two-factor authentication if code matches the enrolled
+// secret and returns the recovery codes. They are only stored hashed, so
+// this is the one time they can be shown.
+func (s *MFAService) Confirm(userID string, code string) ([]string, error) {
+	state, err := repository.GetTOTP(s.pool, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	if state.Secret == nil {
+		return nil, ErrMFANotEnrolled
+	}
+
+	secret, err := s.openSecret(userID, *state.Secret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := totp.Validate(secret, normalizeCode(code), time.Now(), 1)
+
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+
+		if err != nil {
+			return nil, err
+		}
+
+		hashes[i] = hashToken(normalizeCode(codes[i]))
+	}
+
+	err = repository.EnableTOTP(s.pool, userID, step, hashes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off if code is a valid TOTP or
+// recovery code.
+func (s *MFAService) Disable(userID string, code string) error {
+	if err := s.VerifyCode(userID, code); err != nil {
+		return err
+	}
+
+	return repository.DisableTOTP(s.pool, userID)
+}
+
+// VerifyCode accepts a current TOTP code or an unused recovery code of
+// userID and uses it up. A TOTP code cannot be used twice, even within
+// its 30 seconds.
+func (s *MFAService) VerifyCode(userID string, code string) error {
+	state, err := repository.GetTOTP(s.pool, userID)
+
+	if err != nil {
+		return err
+	}
+
+	if !state.Enabled || state.Secret == nil {
+		return ErrMFANotEnabled
+	}
+
+	code = normalizeCode(code)
+
+	if len(code) == totp.Digits {
+		secret, err := s.openSecret(userID, *state.Secret)
+
+		if err != nil {
+			return err
+		}
+
+		step, ok := totp.Validate(secret, code, time.Now(), 1)
+
+		if !ok {
+			return ErrInvalidMFACode
+		}
+
+		ok, err = repository.UseTOTPStep(s.pool, userID, step)
+
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return ErrInvalidMFACode
+		}
+
+		return nil
+	}
+
+	ok, err := repository.UseRecoveryCode(s.pool, userID, hashToken(code))
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// Challenge issues the challenge token for user after a correct password,
+// replacing any earlier one.
+func (s *MFAService) Challenge(user *models.User) (string, time.Time, error) {
+	var expiresAt time.Time = time.Now().Add(s.cfg.MFAChallengeTTL)
+
+	token, err := signPurposeToken(s.keys, repository.TokenPurposeMFAChallenge, user.ID, user.Email, expiresAt)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	err = repository.CreateUserToken(s.pool, user.ID, repository.TokenPurposeMFAChallenge, hashToken(token), expiresAt)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// ChallengeUser checks the signature and expiry of a challenge token and
+// returns its user, without using it up.
+func (s *MFAService) ChallengeUser(token string) (string, error) {
+	userID, err := parsePurposeToken(s.keys, token, repository.TokenPurposeMFAChallenge)
+
+	if err != nil {
+		return "", ErrInvalidMFAChallenge
+	}
+
+	return userID, nil
+}
+
+// CompleteChallenge uses up a challenge token once its code has been
+// verified.
+func (s *MFAService) CompleteChallenge(token string) error {
+	_, err := repository.ConsumeUserToken(s.pool, repository.TokenPurposeMFAChallenge, hashToken(token))
+
+	if err == pgx.ErrNoRows {
+		return ErrInvalidMFAChallenge
+	}
+
+	return err
+}
+
+// newRecoveryCode returns 50 random bits as ten base32 characters, split
+// as xxxxx-xxxxx for reading.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	var code string = strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeCode drops the separators people type or copy along with a
+// code, so recovery codes match however they are written.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
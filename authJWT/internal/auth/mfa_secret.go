@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"todo_api/internal/repository"
+)
+
+// ErrMFAUnavailable means no MFA_ENCRYPTION_KEY is configured, so TOTP
+// secrets can neither be stored nor read.
+var ErrMFAUnavailable = errors.New("two-factor authentication is not configured")
+
+// sealedSecretPrefix marks an encrypted totp_secret. Secrets stored before
+// encryption are plain base32, which never contains a colon.
+const sealedSecretPrefix = "v1:"
+
+// newSecretCipher returns the AES-256-GCM cipher for TOTP secrets, or nil
+// without a key.
+func newSecretCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("MFA encryption key: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sealSecret encrypts secret for userID as "v1:" + base64(nonce | ciphertext).
+// The user ID is authenticated along with it, so a secret copied to another
+// user's row does not decrypt.
+func (s *MFAService) sealSecret(userID string, secret string) (string, error) {
+	if s.aead == nil {
+		return "", ErrMFAUnavailable
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	var sealed []byte = s.aead.Seal(nonce, nonce, []byte(secret), []byte(userID))
+
+	return sealedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openSecret decrypts a stored secret of userID. A secret stored before
+// encryption is returned as is and encrypted in place.
+func (s *MFAService) openSecret(userID string, stored string) (string, error) {
+	if s.aead == nil {
+		return "", ErrMFAUnavailable
+	}
+
+	if !strings.HasPrefix(stored, sealedSecretPrefix) {
+		sealed, err := s.sealSecret(userID, stored)
+
+		if err == nil {
+			err = repository.ReplaceTOTPSecret(s.pool, userID, stored, sealed)
+		}
+
+		if err != nil {
+			log.Printf("Failed to encrypt TOTP secret of user %s: %v", userID, err)
+		}
+
+		return stored, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedSecretPrefix))
+
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("malformed TOTP secret")
+	}
+
+	var nonceSize int = s.aead.NonceSize()
+
+	secret, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(userID))
+
+	if err != nil {
+		return "", fmt.Errorf("decrypting TOTP secret: %w", err)
+	}
+
+	return string(secret), nil
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+	"todo_api/internal/config"
+	"todo_api/internal/mailer"
+	"todo_api/internal/repository"
+	"todo_api/internal/revocation"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidResetToken means the reset token is unknown, expired,
+	// already used or superseded by a newer one.
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+	// ErrWrongPassword means the current password given for a change does
+	// not match.
+	ErrWrongPassword = errors.New("wrong password")
+)
+
+// PasswordService resets forgotten passwords through a mailed link and
+// changes known ones. Either way every session of the user ends: their
+// access tokens are revoked and their refresh tokens invalidated.
+type PasswordService struct {
+	pool        *pgxpool.Pool
+	cfg         *config.Config
+	mailer      mailer.Mailer
+	tokens      *TokenService
+	revocations revocation.Store
+}
+
+func NewPasswordService(pool *pgxpool.Pool, cfg *config.Config, m mailer.Mailer, tokens *TokenService, revocations revocation.Store) *PasswordService {
+	return &PasswordService{pool: pool, cfg: cfg, mailer: m, tokens: tokens, revocations: revocations}
+}
+
+// RequestReset mails a password reset link to email, replacing any earlier
+// one. An unknown email is not an error. Callers should run it in the
+// background, so the response time does not tell whether an account
+// exists either.
+func (s *PasswordService) RequestReset(email string) error {
+	user, err := repository.GetUserByEmail(s.pool, email)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+
+		return err
+	}
+
+	token, err := newOpaqueToken()
+
+	if err != nil {
+		return err
+	}
+
+	err = repository.CreateUserToken(s.pool, user.ID, repository.TokenPurposeResetPassword, hashToken(token), time.Now().Add(s.cfg.PasswordResetTokenTTL))
+
+	if err != nil {
+		return err
+	}
+
+	var link string = s.cfg.PasswordResetURL + "?token=" + url.QueryEscape(token)
+
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Open this link to choose a new password:\n\n%s\n\nThe link expires in %s. If you did not ask to reset your password, ignore this email.\n",
+			link, s.cfg.PasswordResetTokenTTL),
+	})
+}
+
+// Reset uses up a reset token and sets newPassword for its user. The token
+// is checked before the password is hashed, so bogus tokens cost no bcrypt
+// work.
+func (s *PasswordService) Reset(token string, newPassword string) error {
+	valid, err := repository.UserTokenValid(s.pool, repository.TokenPurposeResetPassword, hashToken(token))
+
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		return ErrInvalidResetToken
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+
+	if err != nil {
+		return err
+	}
+
+	userID, err := repository.ResetPassword(s.pool, hashToken(token), string(passwordHash))
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrInvalidResetToken
+		}
+
+		return err
+	}
+
+	return s.endSessions(userID)
+}
+
+// Change sets newPassword for userID if currentPassword is right.
+func (s *PasswordService) Change(userID string, currentPassword string, newPassword string) error {
+	user, err := repository.GetUserByID(s.pool, userID)
+
+	if err != nil {
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword))
+
+	if err != nil {
+		return ErrWrongPassword
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+
+	if err != nil {
+		return err
+	}
+
+	err = repository.UpdatePassword(s.pool, user.ID, string(passwordHash))
+
+	if err != nil {
+		return err
+	}
+
+	return s.endSessions(user.ID)
+}
+
+func (s *PasswordService) endSessions(userID string) error {
+	if err := s.revocations.RevokeUser(userID); err != nil {
+		return fmt.Errorf("password changed, but revoking access tokens failed: %w", err)
+	}
+
+	if err := s.tokens.RevokeUser(userID); err != nil {
+		return fmt.Errorf("password changed, but revoking refresh tokens failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"todo_api/internal/keys"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var errInvalidPurposeToken = errors.New("invalid token")
+
+// signPurposeToken signs a JWT for one purpose other than API access, such
+// as email verification. It names the user in sub rather than user_id, so
+// no access token verifier accepts it.
+func signPurposeToken(keySet *keys.KeySet, purpose string, userID string, email string, expiresAt time.Time) (string, error) {
+	var now time.Time = time.Now()
+
+	jti, err := newUUID()
+
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"purpose": purpose,
+		"jti":     jti,
+		"sub":     userID,
+		"email":   email,
+		"iat":     now.Unix(),
+		"exp":     expiresAt.Unix(),
+	}
+
+	signingKey, err := keySet.SigningKey(now)
+
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	token.Header["kid"] = signingKey.ID
+
+	return token.SignedString(signingKey.Private)
+}
+
+// parsePurposeToken checks the signature, expiry and purpose of a token
+// from signPurposeToken and returns its user.
+func parsePurposeToken(keySet *keys.KeySet, tokenString string, purpose string) (string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+
+		if !ok {
+			return nil, fmt.Errorf("token has no kid header")
+		}
+
+		return keySet.VerificationKey(kid, token.Method.Alg(), time.Now())
+	})
+
+	if err != nil || !token.Valid {
+		return "", errInvalidPurposeToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+
+	if !ok || claims["purpose"] != purpose {
+		return "", errInvalidPurposeToken
+	}
+
+	if _, ok := claims["exp"].(float64); !ok {
+		return "", errInvalidPurposeToken
+	}
+
+	userID, ok := claims["sub"].(string)
+
+	if !ok || userID == "" {
+		return "", errInvalidPurposeToken
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,266 @@
+// Package auth issues and renews the tokens handed out at login: a
+// short-lived JWT access token and an opaque, rotating refresh token. It
+// also issues the single-use tokens mailed to users.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+	"todo_api/internal/config"
+	"todo_api/internal/keys"
+	"todo_api/internal/models"
+	"todo_api/internal/repository"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	// ErrInvalidRefreshToken means the refresh token is unknown, expired or
+	// revoked.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused means an already rotated refresh token was
+	// presented again. Its whole family has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// TokenPair is what a successful login or refresh returns.
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+type TokenService struct {
+	pool *pgxpool.Pool
+	cfg  *config.Config
+	keys *keys.KeySet
+}
+
+func NewTokenService(pool *pgxpool.Pool, cfg *config.Config, keySet *keys.KeySet) *TokenService {
+	return &TokenService{pool: pool, cfg: cfg, keys: keySet}
+}
+
+// Issue starts a new token family for user, e.g. on login.
+func (s *TokenService) Issue(user *models.User) (*TokenPair, error) {
+	familyID, err := newUUID()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issue(user, familyID, "")
+}
+
+// Refresh exchanges a refresh token for a new pair. The presented token is
+// used up; presenting it again revokes every token of its family, so a
+// stolen token stops working as soon as either party uses it twice.
+func (s *TokenService) Refresh(refreshToken string) (*TokenPair, error) {
+	stored, err := repository.GetRefreshTokenByHash(s.pool, hashToken(refreshToken))
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrInvalidRefreshToken
+		}
+
+		return nil, err
+	}
+
+	if stored.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if stored.UsedAt != nil {
+		return nil, s.reused(stored)
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := repository.GetUserByID(s.pool, stored.UserID)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrInvalidRefreshToken
+		}
+
+		return nil, err
+	}
+
+	pair, err := s.issue(user, stored.FamilyID, stored.ID)
+
+	if err == pgx.ErrNoRows {
+		// another request rotated it first
+		return nil, s.reused(stored)
+	}
+
+	return pair, err
+}
+
+// RevokeUser ends every session of a user by revoking all their refresh
+// tokens.
+func (s *TokenService) RevokeUser(userID string) error {
+	return repository.RevokeUserRefreshTokens(s.pool, userID)
+}
+
+// Revoke ends the session a refresh token belongs to, e.g. on logout.
+func (s *TokenService) Revoke(refreshToken string) error {
+	stored, err := repository.GetRefreshTokenByHash(s.pool, hashToken(refreshToken))
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrInvalidRefreshToken
+		}
+
+		return err
+	}
+
+	return repository.RevokeRefreshTokenFamily(s.pool, stored.FamilyID)
+}
+
+// AccessToken signs a JWT for user that expires after AccessTokenTTL with
+// the currently active key, named in the kid header. Its jti identifies it
+// for revocation and gen holds the user's token generation, so a later
+// logout-all rejects it. The user's current roles go into the roles claim,
+// so a role change applies from the next token on.
+func (s *TokenService) AccessToken(user *models.User) (string, time.Time, error) {
+	var now time.Time = time.Now()
+	var expiresAt time.Time = now.Add(s.cfg.AccessTokenTTL)
+
+	jti, err := newUUID()
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	roles, err := repository.GetUserRoles(s.pool, user.ID)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	generation, err := repository.GetTokenGeneration(s.pool, user.ID)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	claims := jwt.MapClaims{
+		"iss":     s.cfg.JWTIssuer,
+		"aud":     s.cfg.JWTAudience,
+		"jti":     jti,
+		"user_id": user.ID,
+		"email":   user.Email,
+		"roles":   roles,
+		"gen":     generation,
+		"iat":     now.Unix(),
+		"exp":     expiresAt.Unix(),
+	}
+
+	signingKey, err := s.keys.SigningKey(now)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	token.Header["kid"] = signingKey.ID
+
+	tokenString, err := token.SignedString(signingKey.Private)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenString, expiresAt, nil
+}
+
+// issue signs an access token and stores a new refresh token in familyID.
+// If replacesID is set, that token is marked used in the same transaction.
+func (s *TokenService) issue(user *models.User, familyID string, replacesID string) (*TokenPair, error) {
+	accessToken, accessExpiresAt, err := s.AccessToken(user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newOpaqueToken()
+
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(s.cfg.RefreshTokenTTL),
+	}
+
+	if replacesID == "" {
+		_, err = repository.CreateRefreshToken(s.pool, stored)
+	} else {
+		_, err = repository.RotateRefreshToken(s.pool, replacesID, stored)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: stored.ExpiresAt,
+	}, nil
+}
+
+func (s *TokenService) reused(stored *models.RefreshToken) error {
+	err := repository.RevokeRefreshTokenFamily(s.pool, stored.FamilyID)
+
+	if err != nil {
+		return fmt.Errorf("%w (revoking family failed: %v)", ErrRefreshTokenReused, err)
+	}
+
+	return ErrRefreshTokenReused
+}
+
+// newOpaqueToken returns 32 random bytes, base64url encoded, for refresh
+// and password reset tokens.
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is how refresh tokens and mailed tokens are stored. The tokens
+// are random or signed, so a fast hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
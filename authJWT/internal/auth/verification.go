@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"todo_api/internal/config"
+	"todo_api/internal/keys"
+	"todo_api/internal/mailer"
+	"todo_api/internal/models"
+	"todo_api/internal/repository"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrInvalidVerificationToken means the token is malformed, expired,
+// already used or superseded by a newer one.
+var ErrInvalidVerificationToken = errors.New("invalid verification token")
+
+// VerificationService mails email verification links and checks them.
+//
+// A verification token is a JWT signed with the same keys as access
+// tokens (see signPurposeToken). Its hash is also stored, which makes it
+// single-use and lets a newer token replace it.
+type VerificationService struct {
+	pool   *pgxpool.Pool
+	cfg    *config.Config
+	keys   *keys.KeySet
+	mailer mailer.Mailer
+}
+
+func NewVerificationService(pool *pgxpool.Pool, cfg *config.Config, keySet *keys.KeySet, m mailer.Mailer) *VerificationService {
+	return &VerificationService{pool: pool, cfg: cfg, keys: keySet, mailer: m}
+}
+
+// Send mails a new verification link to user, replacing any earlier one.
+// It does nothing if the address is already verified.
+func (s *VerificationService) Send(user *models.User) error {
+	if user.EmailVerified {
+		return nil
+	}
+
+	var expiresAt time.Time = time.Now().Add(s.cfg.VerificationTokenTTL)
+
+	tokenString, err := signPurposeToken(s.keys, repository.TokenPurposeVerifyEmail, user.ID, user.Email, expiresAt)
+
+	if err != nil {
+		return err
+	}
+
+	err = repository.CreateUserToken(s.pool, user.ID, repository.TokenPurposeVerifyEmail, hashToken(tokenString), expiresAt)
+
+	if err != nil {
+		return err
+	}
+
+	var link string = strings.TrimRight(s.cfg.AppBaseURL, "/") + "/auth/verify?token=" + url.QueryEscape(tokenString)
+
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Open this link to verify your email address:\n\n%s\n\nThe link expires in %s. If you did not sign up, ignore this email.\n",
+			link, s.cfg.VerificationTokenTTL),
+	})
+}
+
+// Resend mails a new verification link to the user with email, if there
+// is one and they are not verified yet. Callers should run it in the
+// background, so the response time does not tell whether an account
+// exists.
+func (s *VerificationService) Resend(email string) error {
+	user, err := repository.GetUserByEmail(s.pool, email)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+
+		return err
+	}
+
+	return s.Send(user)
+}
+
+// Verify checks a verification token, uses it up and marks the email of
+// its user as verified. It returns the user's id.
+func (s *VerificationService) Verify(tokenString string) (string, error) {
+	if _, err := parsePurposeToken(s.keys, tokenString, repository.TokenPurposeVerifyEmail); err != nil {
+		return "", ErrInvalidVerificationToken
+	}
+
+	userID, err := repository.VerifyEmail(s.pool, hashToken(tokenString))
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", ErrInvalidVerificationToken
+		}
+
+		return "", err
+	}
+
+	return userID, nil
+}
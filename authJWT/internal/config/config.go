@@ -1,8 +1,13 @@
 package config
 
 import (
+	"encoding/base64"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -11,6 +16,74 @@ type Config struct {
 	DatabaseURL string
 	Port        string
 	JWTSecret   string
+	// JWTKeys is a JSON list of signing keys (see keys.KeyConfig);
+	// JWTKeysFile names a file holding the same. Without either, tokens
+	// are signed with HS256 and JWTSecret.
+	JWTKeys     string
+	JWTKeysFile string
+	// JWTIssuer and JWTAudience go into the iss and aud claims of access
+	// tokens; services verifying them (the backend) must expect the same.
+	JWTIssuer   string
+	JWTAudience string
+	// AccessTokenTTL is how long an access token (JWT) is valid.
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is how long a refresh token can be exchanged for a
+	// new token pair.
+	RefreshTokenTTL time.Duration
+	// RevocationCacheTTL is how long a replica may trust its cached
+	// "not revoked" answer for an access token.
+	RevocationCacheTTL time.Duration
+	// RoleCacheTTL is how long the role to permission table is cached
+	// before it is reloaded from the database.
+	RoleCacheTTL time.Duration
+	// AppBaseURL prefixes the links in emails, e.g. https://todo.example.com.
+	AppBaseURL string
+	// Mailer is "log" (the default, for local testing) or "smtp". With
+	// "log", MailFile names a file to append mails to instead of logging
+	// them.
+	Mailer       string
+	MailFile     string
+	MailFrom     string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	// VerificationTokenTTL is how long an email verification link works.
+	VerificationTokenTTL time.Duration
+	// PasswordResetURL is the page the password reset mail links to, with
+	// the token appended as ?token=. It should post the token and the new
+	// password to /auth/reset-password. It defaults to the plain page this
+	// service serves at GET /auth/reset-password.
+	PasswordResetURL string
+	// PasswordResetTokenTTL is how long a password reset link works.
+	PasswordResetTokenTTL time.Duration
+	// RequireEmailVerification refuses logins until the email address is
+	// verified.
+	RequireEmailVerification bool
+	// MFAIssuer names this service in authenticator apps.
+	MFAIssuer string
+	// MFAChallengeTTL is how long a user with two-factor authentication
+	// has to enter a code after their password.
+	MFAChallengeTTL time.Duration
+	// MFAEncryptionKey encrypts TOTP secrets in the database (AES-256-GCM).
+	// MFA_ENCRYPTION_KEY holds it base64 encoded; without it two-factor
+	// authentication is unavailable.
+	MFAEncryptionKey []byte
+	// LoginAttemptStore is where failed logins are counted: "postgres"
+	// (the default, shared by replicas) or "memory".
+	LoginAttemptStore    string
+	LoginMaxFailures     int
+	LoginMaxIPFailures   int
+	LoginFailureWindow   time.Duration
+	LoginLockoutDuration time.Duration
+	LoginDelayBase       time.Duration
+	LoginDelayMax        time.Duration
+	// MailMaxPerEmail and MailMaxPerIP limit how many password reset or
+	// verification mails can be requested for one email and from one
+	// address within MailRateWindow.
+	MailMaxPerEmail int
+	MailMaxPerIP    int
+	MailRateWindow  time.Duration
 }
 
 func Load() (*Config, error) {
@@ -24,7 +97,214 @@ func Load() (*Config, error) {
 		DatabaseURL: os.Getenv("DATABASE_URL"),
 		Port:        os.Getenv("PORT"),
 		JWTSecret:   os.Getenv("JWT_SECRET"),
+		JWTKeys:     os.Getenv("JWT_KEYS"),
+		JWTKeysFile: os.Getenv("JWT_KEYS_FILE"),
+		JWTIssuer:   os.Getenv("JWT_ISSUER"),
+		JWTAudience: os.Getenv("JWT_AUDIENCE"),
+
+		AppBaseURL:       os.Getenv("APP_BASE_URL"),
+		PasswordResetURL: os.Getenv("PASSWORD_RESET_URL"),
+		Mailer:           os.Getenv("MAILER"),
+		MailFile:         os.Getenv("MAIL_FILE"),
+		MailFrom:         os.Getenv("MAIL_FROM"),
+		SMTPHost:         os.Getenv("SMTP_HOST"),
+		SMTPPort:         os.Getenv("SMTP_PORT"),
+		SMTPUsername:     os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:     os.Getenv("SMTP_PASSWORD"),
+
+		RequireEmailVerification: os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+
+		MFAIssuer:         os.Getenv("MFA_ISSUER"),
+		LoginAttemptStore: os.Getenv("LOGIN_ATTEMPT_STORE"),
+	}
+
+	if config.MFAIssuer == "" {
+		config.MFAIssuer = "Todo API"
+	}
+
+	if config.LoginAttemptStore == "" {
+		config.LoginAttemptStore = "postgres"
+	}
+
+	if config.LoginAttemptStore != "postgres" && config.LoginAttemptStore != "memory" {
+		return nil, fmt.Errorf("LOGIN_ATTEMPT_STORE must be postgres or memory, got %q", config.LoginAttemptStore)
+	}
+
+	if config.JWTIssuer == "" {
+		config.JWTIssuer = "authjwt"
+	}
+
+	if config.JWTAudience == "" {
+		config.JWTAudience = "go-fullstack-app"
+	}
+
+	if config.AppBaseURL == "" {
+		config.AppBaseURL = "http://localhost:" + config.Port
+	}
+
+	if config.PasswordResetURL == "" {
+		config.PasswordResetURL = strings.TrimRight(config.AppBaseURL, "/") + "/auth/reset-password"
+	}
+
+	if config.Mailer == "" {
+		config.Mailer = "log"
+	}
+
+	if config.Mailer != "log" && config.Mailer != "smtp" {
+		return nil, fmt.Errorf("MAILER must be log or smtp, got %q", config.Mailer)
+	}
+
+	if config.Mailer == "smtp" && config.SMTPHost == "" {
+		return nil, fmt.Errorf("SMTP_HOST is required when MAILER=smtp")
+	}
+
+	if config.MailFrom == "" {
+		config.MailFrom = "no-reply@localhost"
+	}
+
+	if config.SMTPPort == "" {
+		config.SMTPPort = "587"
+	}
+
+	config.AccessTokenTTL, err = durationEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.RefreshTokenTTL, err = durationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.RevocationCacheTTL, err = durationEnv("REVOCATION_CACHE_TTL", 10*time.Second)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.RoleCacheTTL, err = durationEnv("ROLE_CACHE_TTL", time.Minute)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.VerificationTokenTTL, err = durationEnv("VERIFICATION_TOKEN_TTL", 24*time.Hour)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.PasswordResetTokenTTL, err = durationEnv("PASSWORD_RESET_TOKEN_TTL", time.Hour)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.MFAChallengeTTL, err = durationEnv("MFA_CHALLENGE_TTL", 5*time.Minute)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if key := os.Getenv("MFA_ENCRYPTION_KEY"); key != "" {
+		config.MFAEncryptionKey, err = base64.StdEncoding.DecodeString(key)
+
+		if err != nil || len(config.MFAEncryptionKey) != 32 {
+			return nil, fmt.Errorf("MFA_ENCRYPTION_KEY must be 32 bytes, base64 encoded")
+		}
+	}
+
+	config.LoginMaxFailures, err = intEnv("LOGIN_MAX_FAILURES", 5)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.LoginMaxIPFailures, err = intEnv("LOGIN_MAX_IP_FAILURES", 50)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.LoginFailureWindow, err = durationEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.LoginLockoutDuration, err = durationEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.LoginDelayBase, err = durationEnv("LOGIN_DELAY_BASE", time.Second)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.LoginDelayMax, err = durationEnv("LOGIN_DELAY_MAX", 30*time.Second)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.MailMaxPerEmail, err = intEnv("MAIL_MAX_PER_EMAIL", 3)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.MailMaxPerIP, err = intEnv("MAIL_MAX_PER_IP", 20)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.MailRateWindow, err = durationEnv("MAIL_RATE_WINDOW", time.Hour)
+
+	if err != nil {
+		return nil, err
 	}
 
 	return config, nil
 }
+
+// durationEnv parses a Go duration ("15m", "720h") from key, falling back
+// when it is unset.
+func durationEnv(key string, fallback time.Duration) (time.Duration, error) {
+	var value string = os.Getenv(key)
+
+	if value == "" {
+		return fallback, nil
+	}
+
+	var duration, err = time.ParseDuration(value)
+
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration like 15m or 720h, got %q", key, value)
+	}
+
+	return duration, nil
+}
+
+// intEnv parses a positive integer from key, falling back when it is
+// unset.
+func intEnv(key string, fallback int) (int, error) {
+	var value string = os.Getenv(key)
+
+	if value == "" {
+		return fallback, nil
+	}
+
+	var n, err = strconv.Atoi(value)
+
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", key, value)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"dbmigrate"
+	"io"
+	"log"
+	"todo_api/migrations"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// MigrationsTable keeps the schema version. The migrate CLI needs it as
+// x-migrations-table in the database URL.
+const MigrationsTable = "authjwt_schema_migrations"
+
+// Migrate runs a dbmigrate subcommand (up, down, status, version, force)
+// against databaseURL, writing its output to w.
+func Migrate(databaseURL string, args []string, w io.Writer) error {
+	var db *sql.DB
+	var err error
+	db, err = sql.Open("pgx", databaseURL)
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	var migrator *dbmigrate.Migrator
+	migrator, err = dbmigrate.New(db, migrations.FS, ".")
+
+	if err != nil {
+		return err
+	}
+
+	// a table of our own, so the backend's migrations can share the database
+	migrator.Table = MigrationsTable
+	migrator.LegacyTable = dbmigrate.DefaultTable
+	migrator.Logf = log.Printf
+
+	return dbmigrate.Run(context.Background(), migrator, args, w)
+}
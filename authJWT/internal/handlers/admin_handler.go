@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"todo_api/internal/lockout"
+	"todo_api/internal/repository"
+	"todo_api/internal/revocation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ListUsersQuery struct {
+	Limit  int `form:"limit" binding:"omitempty,min=1,max=100"`
+	Offset int `form:"offset" binding:"omitempty,min=0"`
+}
+
+type SetRolesRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+const defaultUsersLimit = 50
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// adminUserID returns the user_id path parameter, or responds 400 if it is
+// not a UUID, which Postgres would reject.
+func adminUserID(c *gin.Context) (string, bool) {
+	userID := c.Param("user_id")
+
+	if !uuidPattern.MatchString(userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return "", false
+	}
+
+	return userID, true
+}
+
+// ListUsersHandler lists every user with their roles.
+func ListUsersHandler(pool *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var query ListUsersQuery
+
+		if err := c.ShouldBindQuery(&query); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if query.Limit == 0 {
+			query.Limit = defaultUsersLimit
+		}
+
+		users, err := repository.ListUsers(pool, query.Limit, query.Offset)
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, users)
+	}
+}
+
+// SetUserRolesHandler replaces the roles of the user_id user. Their access
+// tokens carry the old roles, so they are revoked; the next refresh picks
+// up the new ones.
+func SetUserRolesHandler(pool *pgxpool.Pool, revocations revocation.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := adminUserID(c)
+
+		if !ok {
+			return
+		}
+
+		var request SetRolesRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := repository.GetUserByID(pool, userID)
+
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = repository.SetUserRoles(pool, user.ID, request.Roles)
+
+		if err != nil {
+			if err == repository.ErrUnknownRole {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := revocations.RevokeUser(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		user.Roles, err = repository.GetUserRoles(pool, user.ID)
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// UnlockUserHandler lifts the login lockout of the user_id user and
+// forgets their failed logins.
+func UnlockUserHandler(pool *pgxpool.Pool, limiter *lockout.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := adminUserID(c)
+
+		if !ok {
+			return
+		}
+
+		user, err := repository.GetUserByID(pool, userID)
+
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := limiter.Unlock(user.Email, user.ID, c.GetString("user_id")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+	}
+}
+
+// The admin todo handlers work like their /todos counterparts on the
+// todos of the user_id user instead of the caller's.
+
+func AdminGetAllTodosHandler(pool *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if userID, ok := adminUserID(c); ok {
+			listTodos(c, pool, userID)
+		}
+	}
+}
+
+func AdminGetToDoByIDHandler(pool *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if userID, ok := adminUserID(c); ok {
+			getTodo(c, pool, userID)
+		}
+	}
+}
+
+func AdminUpdateToDoHandler(pool *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if userID, ok := adminUserID(c); ok {
+			updateTodo(c, pool, userID)
+		}
+	}
+}
+
+func AdminDeleteToDoHandler(pool *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if userID, ok := adminUserID(c); ok {
+			deleteTodo(c, pool, userID)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+	"todo_api/internal/keys"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKSHandler publishes the public signing keys. Verifiers may cache the
+// set for a few minutes; keys are added ahead of their activation, so that
+// is enough for a rotation.
+func JWKSHandler(keySet *keys.KeySet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, keySet.JWKS(time.Now()))
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"todo_api/internal/auth"
+	"todo_api/internal/lockout"
+	"todo_api/internal/models"
+	"todo_api/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MFAChallengeResponse is the login response for users with two-factor
+// authentication. MFAToken goes to /auth/mfa/verify with a code.
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	// Code is a TOTP code or a recovery code.
+	Code string `json:"code" binding:"required"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func mfaChallenge(c *gin.Context, mfa *auth.MFAService, user *models.User) {
+	token, expiresAt, err := mfa.Challenge(user)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor login: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(time.Until(expiresAt).Seconds()),
+	})
+}
+
+// MFAVerifyHandler completes a two-factor login. Wrong codes count as
+// failed logins of the account, so guessing is throttled and locked out
+// like guessing passwords.
+func MFAVerifyHandler(pool *pgxpool.Pool, tokens *auth.TokenService, mfa *auth.MFAService, limiter *lockout.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request MFAVerifyRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userID, err := mfa.ChallengeUser(request.MFAToken)
+
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+			return
+		}
+
+		user, err := repository.GetUserByID(pool, userID)
+
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+			return
+		}
+
+		attempt, ok := beginAttempt(c, limiter, user.Email)
+
+		if !ok {
+			return
+		}
+
+		err = mfa.VerifyCode(user.ID, request.Code)
+
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidMFACode) || errors.Is(err, auth.ErrMFANotEnabled) {
+				if err := limiter.Fail(attempt, user.ID); err != nil {
+					log.Printf("Failed to record failed login: %v", err)
+				}
+
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+				return
+			}
+
+			releaseAttempt(limiter, attempt)
+
+			if errors.Is(err, auth.ErrMFAUnavailable) {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Two-factor authentication is not configured"})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := mfa.CompleteChallenge(request.MFAToken); err != nil {
+			releaseAttempt(limiter, attempt)
+
+			if errors.Is(err, auth.ErrInvalidMFAChallenge) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := limiter.Succeed(attempt); err != nil {
+			log.Printf("Failed to reset login attempts of %s: %v", user.Email, err)
+		}
+
+		pair, err := tokens.Issue(user)
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, newLoginResponse(pair))
+	}
+}
+
+// MFAEnrollHandler starts enrollment for the authenticated user. It has
+// no effect until a code is confirmed.
+func MFAEnrollHandler(pool *pgxpool.Pool, mfa *auth.MFAService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := repository.GetUserByID(pool, c.GetString("user_id"))
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		enrollment, err := mfa.Enroll(user)
+
+		if err != nil {
+			if errors.Is(err, auth.ErrMFAAlreadyEnabled) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+				return
+			}
+
+			if errors.Is(err, auth.ErrMFAUnavailable) {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Two-factor authentication is not configured"})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, enrollment)
+	}
+}
+
+// MFAConfirmHandler enables two-factor authentication with a code from
+// the enrolled secret and returns the recovery codes, once.
+func MFAConfirmHandler(mfa *auth.MFAService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request MFACodeRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		codes, err := mfa.Confirm(c.GetString("user_id"), request.Code)
+
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrMFAAlreadyEnabled):
+				c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			case errors.Is(err, auth.ErrMFANotEnrolled):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Enroll before confirming a code"})
+			case errors.Is(err, auth.ErrInvalidMFACode):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+			case errors.Is(err, auth.ErrMFAUnavailable):
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Two-factor authentication is not configured"})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Two-factor authentication enabled",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// MFADisableHandler turns two-factor authentication off given a TOTP or
+// recovery code. Wrong codes count as failed logins of the account, as in
+// MFAVerifyHandler, so a stolen access token cannot be used to guess one.
+func MFADisableHandler(pool *pgxpool.Pool, mfa *auth.MFAService, limiter *lockout.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request MFACodeRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := repository.GetUserByID(pool, c.GetString("user_id"))
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		attempt, ok := beginAttempt(c, limiter, user.Email)
+
+		if !ok {
+			return
+		}
+
+		err = mfa.Disable(user.ID, request.Code)
+
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidMFACode) {
+				if err := limiter.Fail(attempt, user.ID); err != nil {
+					log.Printf("Failed to record failed login: %v", err)
+				}
+			} else {
+				releaseAttempt(limiter, attempt)
+			}
+
+			switch {
+			case errors.Is(err, auth.ErrMFANotEnabled):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+			case errors.Is(err, auth.ErrInvalidMFACode):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+			case errors.Is(err, auth.ErrMFAUnavailable):
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Two-factor authentication is not configured"})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+
+		if err := limiter.Succeed(attempt); err != nil {
+			log.Printf("Failed to reset login attempts of %s: %v", user.Email, err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	_ "embed"
+	"errors"
+	"log"
+	"net/http"
+	"todo_api/internal/auth"
+	"todo_api/internal/lockout"
+	"todo_api/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// resetPasswordPage is the page the reset mail links to by default.
+//
+//go:embed reset_password.html
+var resetPasswordPage []byte
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// ForgotPasswordHandler mails a reset link. The mail goes out in the
+// background and unknown emails get the same answer, so it cannot be used
+// to find accounts. Requests beyond the throttle get 429.
+func ForgotPasswordHandler(passwords *auth.PasswordService, throttle *lockout.Throttle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request ForgotPasswordRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !allowMail(c, throttle, request.Email) {
+			return
+		}
+
+		go func(email string) {
+			if err := passwords.RequestReset(email); err != nil {
+				log.Printf("Failed to send password reset email: %v", err)
+			}
+		}(request.Email)
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a password reset email has been sent"})
+	}
+}
+
+// allowMail counts a mail request for email from the client address. Over
+// the limit it answers 429, or 503 if the count is unavailable, and
+// returns false.
+func allowMail(c *gin.Context, throttle *lockout.Throttle, email string) bool {
+	wait, err := throttle.Allow(email, c.ClientIP())
+
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to check request rate"})
+		return false
+	}
+
+	if wait > 0 {
+		retryLater(c, wait, "Too many requests, try again later")
+		return false
+	}
+
+	return true
+}
+
+// ResetPasswordPageHandler serves a form that posts the token from the
+// ?token= query and a new password to ResetPasswordHandler, for when no
+// client page is configured as PASSWORD_RESET_URL.
+func ResetPasswordPageHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", resetPasswordPage)
+	}
+}
+
+// ResetPasswordHandler sets a new password with the token from the reset
+// mail and logs the user out everywhere.
+func ResetPasswordHandler(passwords *auth.PasswordService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request ResetPasswordRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(request.Password) < minPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 6 characters long!"})
+			return
+		}
+
+		err := passwords.Reset(request.Token, request.Password)
+
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidResetToken) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired password reset token"})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully, please log in again"})
+	}
+}
+
+// ChangePasswordHandler changes the password of the authenticated user and
+// logs them out everywhere, this session included. A wrong current
+// password counts as a failed login of the account, so a stolen access
+// token cannot be used to guess it.
+func ChangePasswordHandler(pool *pgxpool.Pool, passwords *auth.PasswordService, limiter *lockout.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request ChangePasswordRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(request.NewPassword) < minPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 6 characters long!"})
+			return
+		}
+
+		user, err := repository.GetUserByID(pool, c.GetString("user_id"))
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password: " + err.Error()})
+			return
+		}
+
+		attempt, ok := beginAttempt(c, limiter, user.Email)
+
+		if !ok {
+			return
+		}
+
+		err = passwords.Change(user.ID, request.CurrentPassword, request.NewPassword)
+
+		if errors.Is(err, auth.ErrWrongPassword) {
+			if err := limiter.Fail(attempt, user.ID); err != nil {
+				log.Printf("Failed to record failed login: %v", err)
+			}
+
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+
+		if err != nil {
+			releaseAttempt(limiter, attempt)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password: " + err.Error()})
+			return
+		}
+
+		if err := limiter.Succeed(attempt); err != nil {
+			log.Printf("Failed to reset login attempts of %s: %v", user.Email, err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully, please log in again"})
+	}
+}
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+	"todo_api/internal/models"
 	"todo_api/internal/repository"
 
 	"github.com/gin-gonic/gin"
@@ -51,6 +55,24 @@ func CreateTodoHandler(pool *pgxpool.Pool) gin.HandlerFunc {
 	}
 }
 
+// ListTodosQuery holds the GET /todos query parameters. Dates accept
+// RFC 3339 timestamps or plain YYYY-MM-DD days; a plain day in a _to bound
+// includes that whole day.
+type ListTodosQuery struct {
+	Completed   *bool  `form:"completed"`
+	Search      string `form:"q"`
+	CreatedFrom string `form:"created_from"`
+	CreatedTo   string `form:"created_to"`
+	UpdatedFrom string `form:"updated_from"`
+	UpdatedTo   string `form:"updated_to"`
+	Sort        string `form:"sort" binding:"omitempty,oneof=created_at updated_at title id"`
+	Order       string `form:"order" binding:"omitempty,oneof=asc desc"`
+	Limit       int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor      string `form:"cursor"`
+}
+
+const defaultTodosLimit = 20
+
 func GetAllTodosHandler(pool *pgxpool.Pool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userIDInterface, exists := c.Get("user_id")
@@ -62,15 +84,111 @@ func GetAllTodosHandler(pool *pgxpool.Pool) gin.HandlerFunc {
 		// interface{} or any{},
 		userID := userIDInterface.(string)
 
-		todos, err := repository.GetAllTodos(pool, userID)
+		listTodos(c, pool, userID)
+	}
+}
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+// listTodos responds with a page of the todos of userID.
+func listTodos(c *gin.Context, pool *pgxpool.Pool, userID string) {
+	var query ListTodosQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter, err := query.filter()
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := repository.GetAllTodos(pool, userID, filter)
+
+	if err != nil {
+		if err == repository.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
 			return
 		}
 
-		c.JSON(http.StatusOK, todos)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// filter turns the query parameters into a repository filter. Sorting
+// defaults to newest first; order defaults to desc for dates and asc
+// otherwise.
+func (q ListTodosQuery) filter() (models.TodoFilter, error) {
+	var filter models.TodoFilter = models.TodoFilter{
+		Completed: q.Completed,
+		Search:    strings.TrimSpace(q.Search),
+		SortBy:    q.Sort,
+		Limit:     q.Limit,
+		Cursor:    q.Cursor,
+	}
+
+	if filter.SortBy == "" {
+		filter.SortBy = "created_at"
+	}
+
+	switch q.Order {
+	case "asc":
+		filter.SortDesc = false
+	case "desc":
+		filter.SortDesc = true
+	default:
+		filter.SortDesc = filter.SortBy == "created_at" || filter.SortBy == "updated_at"
 	}
+
+	if filter.Limit == 0 {
+		filter.Limit = defaultTodosLimit
+	}
+
+	var err error
+
+	if filter.CreatedFrom, err = parseDateParam("created_from", q.CreatedFrom, false); err != nil {
+		return filter, err
+	}
+	if filter.CreatedTo, err = parseDateParam("created_to", q.CreatedTo, true); err != nil {
+		return filter, err
+	}
+	if filter.UpdatedFrom, err = parseDateParam("updated_from", q.UpdatedFrom, false); err != nil {
+		return filter, err
+	}
+	if filter.UpdatedTo, err = parseDateParam("updated_to", q.UpdatedTo, true); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// parseDateParam parses an RFC 3339 timestamp or a YYYY-MM-DD day. With
+// endOfDay, a plain day is moved to the start of the next day so that an
+// exclusive upper bound still covers it.
+func parseDateParam(name, value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", name)
+	}
+
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return &t, nil
 }
 
 func GetToDoByIDHandler(pool *pgxpool.Pool) gin.HandlerFunc {
@@ -84,30 +202,37 @@ func GetToDoByIDHandler(pool *pgxpool.Pool) gin.HandlerFunc {
 		// interface{} or any{},
 		userID := userIDInterface.(string)
 
-		idStr := c.Param("id")
-		// "2" ------------> 2, nil
-		// "a" ------------> 0, error ("invalid syntax")
+		getTodo(c, pool, userID)
+	}
+}
 
-		id, err := strconv.Atoi(idStr)
+// getTodo responds with the todo named by the id parameter if it belongs
+// to userID.
+func getTodo(c *gin.Context, pool *pgxpool.Pool, userID string) {
+	idStr := c.Param("id")
+	// "2" ------------> 2, nil
+	// "a" ------------> 0, error ("invalid syntax")
 
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID"})
-			return
-		}
+	id, err := strconv.Atoi(idStr)
 
-		todo, err := repository.GetToDoByID(pool, id, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID"})
+		return
+	}
 
-		if err != nil {
-			if err == pgx.ErrNoRows {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
-				return
-			}
+	todo, err := repository.GetToDoByID(pool, id, userID)
 
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+			return
 		}
 
-		c.JSON(http.StatusOK, todo)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+
+	c.JSON(http.StatusOK, todo)
 }
 
 func UpdateToDoHandler(pool *pgxpool.Pool) gin.HandlerFunc {
@@ -121,58 +246,64 @@ func UpdateToDoHandler(pool *pgxpool.Pool) gin.HandlerFunc {
 		// interface{} or any{},
 		userID := userIDInterface.(string)
 
-		idStr := c.Param("id")
+		updateTodo(c, pool, userID)
+	}
+}
 
-		id, err := strconv.Atoi(idStr)
+// updateTodo applies an UpdateTodoInput to the todo named by the id
+// parameter if it belongs to userID.
+func updateTodo(c *gin.Context, pool *pgxpool.Pool, userID string) {
+	idStr := c.Param("id")
 
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID"})
-		}
+	id, err := strconv.Atoi(idStr)
 
-		var input UpdateTodoInput
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID"})
+		return
+	}
 
-		if err := c.ShouldBindJSON(&input); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
+	var input UpdateTodoInput
 
-		if input.Title == nil && input.Completed == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one field (title or completed) must be provided"})
-			return
-		}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		existing, err := repository.GetToDoByID(pool, id, userID)
+	if input.Title == nil && input.Completed == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one field (title or completed) must be provided"})
+		return
+	}
 
-		if err != nil {
-			if err == pgx.ErrNoRows {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
-				return
-			}
+	existing, err := repository.GetToDoByID(pool, id, userID)
 
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
 			return
 		}
 
-		title := existing.Title
-		if input.Title != nil {
-			title = *input.Title
-		}
-
-		completed := existing.Completed
-		if input.Completed != nil {
-			completed = *input.Completed
-		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-		todo, err := repository.UpdateToDo(pool, id, title, completed, userID)
+	title := existing.Title
+	if input.Title != nil {
+		title = *input.Title
+	}
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+	completed := existing.Completed
+	if input.Completed != nil {
+		completed = *input.Completed
+	}
 
-		c.JSON(http.StatusOK, todo)
+	todo, err := repository.UpdateToDo(pool, id, title, completed, userID)
 
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+
+	c.JSON(http.StatusOK, todo)
 }
 
 func DeleteToDoHandler(pool *pgxpool.Pool) gin.HandlerFunc {
@@ -186,25 +317,33 @@ func DeleteToDoHandler(pool *pgxpool.Pool) gin.HandlerFunc {
 		// interface{} or any{},
 		userID := userIDInterface.(string)
 
-		idStr := c.Param("id")
+		deleteTodo(c, pool, userID)
+	}
+}
 
-		id, err := strconv.Atoi(idStr)
+// deleteTodo deletes the todo named by the id parameter if it belongs to
+// userID.
+func deleteTodo(c *gin.Context, pool *pgxpool.Pool, userID string) {
+	idStr := c.Param("id")
 
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID"})
-		}
+	id, err := strconv.Atoi(idStr)
 
-		err = repository.DeleteToDo(pool, id, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID"})
+		return
+	}
 
-		if err != nil {
-			if err.Error() == "todo with id "+idStr+" not found" {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
-				return
-			}
+	err = repository.DeleteToDo(pool, id, userID)
 
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err != nil {
+		if err.Error() == "todo with id "+idStr+" not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Todo deleted successfully"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Todo deleted successfully"})
 }
@@ -1,19 +1,26 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
-	"todo_api/internal/config"
+	"todo_api/internal/auth"
+	"todo_api/internal/lockout"
 	"todo_api/internal/models"
 	"todo_api/internal/repository"
+	"todo_api/internal/revocation"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const minPasswordLength = 6
+
 type RegisterRequest struct {
 	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required"`
@@ -24,11 +31,32 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// LoginResponse carries a token pair. Token is the access token, kept
+// under its old name for existing clients.
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+func newLoginResponse(pair *auth.TokenPair) LoginResponse {
+	return LoginResponse{
+		Token:        pair.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(pair.AccessExpiresAt).Seconds()),
+		RefreshToken: pair.RefreshToken,
+	}
 }
 
-func CreateUserHandler(pool *pgxpool.Pool) gin.HandlerFunc {
+// CreateUserHandler registers a user and mails them a verification link.
+// A failed mail does not fail the registration; the user can ask for
+// another link.
+func CreateUserHandler(pool *pgxpool.Pool, verifier *auth.VerificationService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var registerRequest RegisterRequest
 
@@ -37,7 +65,7 @@ func CreateUserHandler(pool *pgxpool.Pool) gin.HandlerFunc {
 			return
 		}
 
-		if len(registerRequest.Password) < 6 {
+		if len(registerRequest.Password) < minPasswordLength {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 6 characters long!"})
 			return
 		}
@@ -66,11 +94,22 @@ func CreateUserHandler(pool *pgxpool.Pool) gin.HandlerFunc {
 			return
 		}
 
+		if err := verifier.Send(createdUser); err != nil {
+			log.Printf("Failed to send verification email to %s: %v", createdUser.Email, err)
+		}
+
 		c.JSON(http.StatusCreated, createdUser)
 	}
 }
 
-func LoginHandler(pool *pgxpool.Pool, cfg *config.Config) gin.HandlerFunc {
+// LoginHandler exchanges credentials for a token pair. With
+// requireVerifiedEmail, users who have not verified their address are
+// refused with 403. Each attempt is counted as a failure before the
+// password is checked and taken back unless it fails; attempts that come
+// too soon after failures, or for a locked account or address, are
+// refused with 429 and Retry-After. Users with two-factor authentication get an
+// MFAChallengeResponse instead of tokens.
+func LoginHandler(pool *pgxpool.Pool, tokens *auth.TokenService, mfa *auth.MFAService, limiter *lockout.Limiter, requireVerifiedEmail bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var loginRequest LoginRequest
 
@@ -79,35 +118,194 @@ func LoginHandler(pool *pgxpool.Pool, cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
+		attempt, ok := beginAttempt(c, limiter, loginRequest.Email)
+
+		if !ok {
+			return
+		}
+
 		user, err := repository.GetUserByEmail(pool, loginRequest.Email)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			if err == pgx.ErrNoRows {
+				loginFailed(c, limiter, attempt, "")
+				return
+			}
+
+			releaseAttempt(limiter, attempt)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
 		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginRequest.Password))
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			loginFailed(c, limiter, attempt, user.ID)
 			return
 		}
 
-		// map[string]interface{}
-		// map[string]any{}
-		claims := jwt.MapClaims{
-			"user_id": user.ID,
-			"email":   user.Email,
-			"exp":     time.Now().Add(24 * time.Hour).Unix(),
+		if requireVerifiedEmail && !user.EmailVerified {
+			releaseAttempt(limiter, attempt)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+			return
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		// failures are only cleared once the second factor is through
+		if user.TOTPEnabled {
+			releaseAttempt(limiter, attempt)
+			mfaChallenge(c, mfa, user)
+			return
+		}
 
-		tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+		if err := limiter.Succeed(attempt); err != nil {
+			log.Printf("Failed to reset login attempts of %s: %v", user.Email, err)
+		}
+
+		pair, err := tokens.Issue(user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token: " + err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, LoginResponse{Token: tokenString})
+		c.JSON(http.StatusOK, newLoginResponse(pair))
+	}
+}
+
+// beginAttempt reserves a login attempt for email from the client address.
+// If that is refused it answers 429 or 503 and returns false.
+func beginAttempt(c *gin.Context, limiter *lockout.Limiter, email string) (*lockout.Attempt, bool) {
+	attempt, wait, err := limiter.Begin(email, c.ClientIP())
+
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to check login attempts"})
+		return nil, false
+	}
+
+	if attempt == nil {
+		tooManyAttempts(c, wait)
+		return nil, false
+	}
+
+	return attempt, true
+}
+
+// releaseAttempt takes back an attempt that neither failed nor succeeded.
+func releaseAttempt(limiter *lockout.Limiter, attempt *lockout.Attempt) {
+	if err := limiter.Release(attempt); err != nil {
+		log.Printf("Failed to release login attempt: %v", err)
+	}
+}
+
+// tooManyAttempts answers 429 for a throttled login.
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	retryLater(c, wait, "Too many failed login attempts, try again later")
+}
+
+// retryLater answers 429 with the wait rounded up to whole seconds.
+func retryLater(c *gin.Context, wait time.Duration, message string) {
+	retryAfter := int64((wait + time.Second - 1) / time.Second)
+	c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       message,
+		"retry_after": retryAfter,
+	})
+}
+
+// loginFailed keeps attempt as a failed login and answers 401. userID is
+// empty for unknown emails.
+func loginFailed(c *gin.Context, limiter *lockout.Limiter, attempt *lockout.Attempt, userID string) {
+	if err := limiter.Fail(attempt, userID); err != nil {
+		log.Printf("Failed to record failed login: %v", err)
+	}
+
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+}
+
+func RefreshHandler(tokens *auth.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var refreshRequest RefreshRequest
+
+		if err := c.ShouldBindJSON(&refreshRequest); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		pair, err := tokens.Refresh(refreshRequest.RefreshToken)
+
+		if err != nil {
+			if errors.Is(err, auth.ErrRefreshTokenReused) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token already used, please log in again"})
+				return
+			}
+
+			if errors.Is(err, auth.ErrInvalidRefreshToken) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, newLoginResponse(pair))
+	}
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LogoutHandler revokes the access token of the request and, if one is
+// given, the session of the refresh token.
+func LogoutHandler(tokens *auth.TokenService, revocations revocation.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var logoutRequest LogoutRequest
+
+		// the body is optional
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&logoutRequest); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		userID := c.GetString("user_id")
+		jti := c.GetString("jti")
+		expiresAt := c.GetTime("token_expires_at")
+
+		if err := revocations.Revoke(jti, userID, expiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out: " + err.Error()})
+			return
+		}
+
+		if logoutRequest.RefreshToken != "" {
+			err := tokens.Revoke(logoutRequest.RefreshToken)
+
+			// an unknown refresh token is already logged out
+			if err != nil && !errors.Is(err, auth.ErrInvalidRefreshToken) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out: " + err.Error()})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+	}
+}
+
+// LogoutAllHandler revokes every access and refresh token of the user.
+func LogoutAllHandler(tokens *auth.TokenService, revocations revocation.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+
+		if err := revocations.RevokeUser(userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out: " + err.Error()})
+			return
+		}
+
+		if err := tokens.RevokeUser(userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
 	}
 }
 
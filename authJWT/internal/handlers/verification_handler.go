@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"todo_api/internal/auth"
+	"todo_api/internal/lockout"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// VerifyEmailHandler takes the token from the ?token= query of the mailed
+// link (GET) or from a JSON body (POST).
+func VerifyEmailHandler(verifier *auth.VerificationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request VerifyEmailRequest
+
+		if err := c.ShouldBind(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		_, err := verifier.Verify(request.Token)
+
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidVerificationToken) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+	}
+}
+
+// ResendVerificationHandler mails a new verification link. The mail goes
+// out in the background and the answer is the same whether or not the
+// email is registered or already verified, so it cannot be used to find
+// accounts. Requests beyond the throttle get 429.
+func ResendVerificationHandler(verifier *auth.VerificationService, throttle *lockout.Throttle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request ResendVerificationRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !allowMail(c, throttle, request.Email) {
+			return
+		}
+
+		go func(email string) {
+			if err := verifier.Resend(email); err != nil {
+				log.Printf("Failed to send verification email: %v", err)
+			}
+		}(request.Email)
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists and is not verified, a verification email has been sent"})
+	}
+}
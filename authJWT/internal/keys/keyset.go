@@ -0,0 +1,168 @@
+// Package keys holds the keys access tokens are signed and verified with.
+// Each key has an id (the JWT "kid" header), an algorithm and a schedule:
+// it is published and accepted from the start, used for signing from
+// ActiveFrom, and dropped at RetireAt. Asymmetric public keys are published
+// as a JWKS so other services can verify tokens without a shared secret.
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// ErrUnknownKey means a token names a kid that is not (or no longer) in
+// the key set.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Key is one signing or verification key.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Private signs tokens. It is nil for verify-only keys, e.g. the
+	// public half of a key another instance signs with.
+	Private crypto.PrivateKey
+	// Public verifies tokens. For HS256 it is the shared secret.
+	Public crypto.PublicKey
+	// ActiveFrom is when the key starts signing. Zero means always.
+	ActiveFrom time.Time
+	// RetireAt is when the key stops being accepted. Zero means never.
+	RetireAt time.Time
+}
+
+func (k *Key) retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+// KeySet is an immutable set of keys.
+type KeySet struct {
+	keys []*Key
+}
+
+// NewKeySet checks keys and returns them as a set. At least one key must be
+// able to sign.
+func NewKeySet(keys []*Key) (*KeySet, error) {
+	var seen map[string]bool = map[string]bool{}
+	var canSign bool
+
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("keys: every key needs a kid")
+		}
+
+		if seen[key.ID] {
+			return nil, fmt.Errorf("keys: duplicate kid %q", key.ID)
+		}
+
+		seen[key.ID] = true
+		canSign = canSign || key.Private != nil
+	}
+
+	if !canSign {
+		return nil, errors.New("keys: no key with a private key to sign with")
+	}
+
+	var sorted []*Key = append([]*Key(nil), keys...)
+
+	// newest schedule first, so SigningKey picks the latest active key
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActiveFrom.After(sorted[j].ActiveFrom)
+	})
+
+	return &KeySet{keys: sorted}, nil
+}
+
+// SigningKey returns the key to sign new tokens with at now: the private
+// key with the latest ActiveFrom that has started and is not retired.
+func (s *KeySet) SigningKey(now time.Time) (*Key, error) {
+	for _, key := range s.keys {
+		if key.Private != nil && !now.Before(key.ActiveFrom) && !key.retired(now) {
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("keys: no signing key is active")
+}
+
+// VerificationKey returns the key for a token's kid and alg header. The alg
+// must match the key, so a token cannot pick a weaker algorithm than the
+// key was made for.
+func (s *KeySet) VerificationKey(kid string, alg string, now time.Time) (interface{}, error) {
+	for _, key := range s.keys {
+		if key.ID != kid || key.retired(now) {
+			continue
+		}
+
+		if key.Method.Alg() != alg {
+			return nil, fmt.Errorf("kid %q is %s, token says %s", kid, key.Method.Alg(), alg)
+		}
+
+		return key.Public, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// JSONWebKey is one entry of a JWKS (RFC 7517). Only public parameters are
+// ever set.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP (Ed25519)
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys accepted at now, including scheduled keys
+// that do not sign yet so verifiers learn them ahead of the switch.
+// Symmetric keys are never published.
+func (s *KeySet) JWKS(now time.Time) JSONWebKeySet {
+	var set JSONWebKeySet = JSONWebKeySet{Keys: []JSONWebKey{}}
+
+	for _, key := range s.keys {
+		if key.retired(now) {
+			continue
+		}
+
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	return set
+}
@@ -0,0 +1,184 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// KeyConfig describes one key in JWT_KEYS / JWT_KEYS_FILE. Give either a
+// private key (to sign and verify) or only a public key (to verify), as a
+// PEM file path or inline PEM.
+type KeyConfig struct {
+	ID             string     `json:"kid"`
+	Algorithm      string     `json:"alg"`
+	PrivateKeyFile string     `json:"private_key_file"`
+	PrivateKey     string     `json:"private_key"`
+	PublicKeyFile  string     `json:"public_key_file"`
+	PublicKey      string     `json:"public_key"`
+	ActiveFrom     *time.Time `json:"active_from"`
+	RetireAt       *time.Time `json:"retire_at"`
+}
+
+// Load builds the key set from a JSON list of KeyConfig, read from
+// keysJSON or else from the file at keysFile. With neither, it falls back
+// to a single HS256 key from secret, which is never published.
+func Load(keysJSON string, keysFile string, secret string) (*KeySet, error) {
+	if keysJSON == "" && keysFile != "" {
+		data, err := os.ReadFile(keysFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("keys: %w", err)
+		}
+
+		keysJSON = string(data)
+	}
+
+	if strings.TrimSpace(keysJSON) == "" {
+		if secret == "" {
+			return nil, errors.New("keys: set JWT_KEYS, JWT_KEYS_FILE or JWT_SECRET")
+		}
+
+		return NewKeySet([]*Key{{
+			ID:      "default",
+			Method:  jwt.SigningMethodHS256,
+			Private: []byte(secret),
+			Public:  []byte(secret),
+		}})
+	}
+
+	var configs []KeyConfig
+
+	if err := json.Unmarshal([]byte(keysJSON), &configs); err != nil {
+		return nil, fmt.Errorf("keys: invalid key configuration: %w", err)
+	}
+
+	var loaded []*Key
+
+	for _, config := range configs {
+		key, err := config.load()
+
+		if err != nil {
+			return nil, fmt.Errorf("keys: kid %q: %w", config.ID, err)
+		}
+
+		loaded = append(loaded, key)
+	}
+
+	return NewKeySet(loaded)
+}
+
+func (config KeyConfig) load() (*Key, error) {
+	var key *Key = &Key{ID: config.ID}
+
+	if config.ActiveFrom != nil {
+		key.ActiveFrom = *config.ActiveFrom
+	}
+
+	if config.RetireAt != nil {
+		key.RetireAt = *config.RetireAt
+	}
+
+	privatePEM, err := pemFrom(config.PrivateKey, config.PrivateKeyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	publicPEM, err := pemFrom(config.PublicKey, config.PublicKeyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if privatePEM == nil && publicPEM == nil {
+		return nil, errors.New("needs a private or public key")
+	}
+
+	switch config.Algorithm {
+	case "RS256":
+		key.Method = jwt.SigningMethodRS256
+
+		if privatePEM != nil {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if private.N.BitLen() < 2048 {
+				return nil, errors.New("RSA keys must be at least 2048 bits")
+			}
+
+			key.Private = private
+			key.Public = &private.PublicKey
+		} else {
+			public, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+
+			if err != nil {
+				return nil, err
+			}
+
+			key.Public = public
+		}
+
+	case "EdDSA":
+		key.Method = jwt.SigningMethodEdDSA
+
+		if privatePEM != nil {
+			parsed, err := jwt.ParseEdPrivateKeyFromPEM(privatePEM)
+
+			if err != nil {
+				return nil, err
+			}
+
+			private, ok := parsed.(ed25519.PrivateKey)
+
+			if !ok {
+				return nil, errors.New("not an Ed25519 private key")
+			}
+
+			key.Private = private
+			key.Public = private.Public().(ed25519.PublicKey)
+		} else {
+			parsed, err := jwt.ParseEdPublicKeyFromPEM(publicPEM)
+
+			if err != nil {
+				return nil, err
+			}
+
+			public, ok := parsed.(ed25519.PublicKey)
+
+			if !ok {
+				return nil, errors.New("not an Ed25519 public key")
+			}
+
+			key.Public = public
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q (use RS256 or EdDSA)", config.Algorithm)
+	}
+
+	return key, nil
+}
+
+// pemFrom returns inline PEM, or the contents of file, or nil if neither
+// is set.
+func pemFrom(inline string, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+
+	if file == "" {
+		return nil, nil
+	}
+
+	return os.ReadFile(file)
+}
@@ -0,0 +1,241 @@
+package lockout
+
+import (
+	"log"
+	"strings"
+	"time"
+	"todo_api/internal/models"
+)
+
+// Lockout audit events.
+const (
+	EventAccountLocked   = "account_locked"
+	EventIPLocked        = "ip_locked"
+	EventAccountUnlocked = "account_unlocked"
+)
+
+// Policy sets the limits of a Limiter.
+type Policy struct {
+	// MaxAccountFailures failed logins for an email within Window lock it
+	// for LockoutDuration.
+	MaxAccountFailures int
+	// MaxIPFailures failed logins from one address within Window lock the
+	// address for LockoutDuration, whatever emails were tried.
+	MaxIPFailures   int
+	Window          time.Duration
+	LockoutDuration time.Duration
+	// After the nth failure for an email, the next attempt must wait
+	// BaseDelay * 2^(n-1), at most MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Limiter applies a Policy to login attempts.
+type Limiter struct {
+	store  Store
+	policy Policy
+}
+
+func NewLimiter(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy}
+}
+
+// Attempt is a login attempt reserved by Begin. It already counts as a
+// failure; Succeed or Release takes it back.
+type Attempt struct {
+	email   string
+	ip      string
+	account *models.LoginAttempts
+	address *models.LoginAttempts
+}
+
+// Begin reserves a login attempt for email from ip before the password is
+// checked. The reservation counts as a failure right away, so parallel
+// guesses cannot all pass a check before any of them has failed. If the
+// attempt comes too soon after failures, or the account or address is
+// locked, Begin reserves nothing and returns how long to wait instead.
+func (l *Limiter) Begin(email string, ip string) (*Attempt, time.Duration, error) {
+	var now time.Time = time.Now()
+	var windowStart time.Time = now.Add(-l.policy.Window)
+
+	address, ok, err := l.store.Reserve(ipKey(ip), now, windowStart, Limits{MaxFailures: l.policy.MaxIPFailures})
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !ok {
+		wait, err := l.wait(ipKey(ip), Limits{MaxFailures: l.policy.MaxIPFailures}, now)
+		return nil, wait, err
+	}
+
+	account, ok, err := l.store.Reserve(accountKey(email), now, windowStart, l.accountLimits())
+
+	if err != nil || !ok {
+		if err := l.store.Release(address.Key); err != nil {
+			log.Printf("Failed to release login attempt of %s: %v", address.Key, err)
+		}
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !ok {
+		wait, err := l.wait(accountKey(email), l.accountLimits(), now)
+		return nil, wait, err
+	}
+
+	return &Attempt{email: email, ip: ip, account: account, address: address}, 0, nil
+}
+
+// Fail keeps the reserved attempt as a failure and locks the account or
+// the address once it reaches its limit. userID is empty if no account
+// has that email.
+func (l *Limiter) Fail(attempt *Attempt, userID string) error {
+	var now time.Time = time.Now()
+
+	if attempt.account.Failures >= l.policy.MaxAccountFailures {
+		if err := l.lock(EventAccountLocked, attempt.account, now, userID, attempt.ip); err != nil {
+			return err
+		}
+	}
+
+	if attempt.address.Failures >= l.policy.MaxIPFailures {
+		if err := l.lock(EventIPLocked, attempt.address, now, "", attempt.ip); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Succeed clears the failures of the account after a successful login.
+// Failures of the address are kept, only this attempt is taken back, so
+// logging into one's own account does not reset a guessing run against
+// others.
+func (l *Limiter) Succeed(attempt *Attempt) error {
+	if err := l.store.Reset(attempt.account.Key); err != nil {
+		return err
+	}
+
+	return l.store.Release(attempt.address.Key)
+}
+
+// Release takes the attempt back without clearing earlier failures, for
+// attempts that neither failed nor completed a login: the password was
+// right but a second factor is still due, or checking it failed.
+func (l *Limiter) Release(attempt *Attempt) error {
+	if err := l.store.Release(attempt.account.Key); err != nil {
+		return err
+	}
+
+	return l.store.Release(attempt.address.Key)
+}
+
+// Unlock lifts the lockout and forgets the failures of email on behalf of
+// the administrator actorID.
+func (l *Limiter) Unlock(email string, userID string, actorID string) error {
+	var key string = accountKey(email)
+
+	attempts, err := l.store.Get(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := l.store.Reset(key); err != nil {
+		return err
+	}
+
+	l.audit(&models.LockoutEvent{
+		Event:     EventAccountUnlocked,
+		Key:       key,
+		UserID:    userID,
+		ActorID:   actorID,
+		Failures:  attempts.Failures,
+		CreatedAt: time.Now(),
+	})
+
+	return nil
+}
+
+func (l *Limiter) lock(event string, attempts *models.LoginAttempts, now time.Time, userID string, ip string) error {
+	var until time.Time = now.Add(l.policy.LockoutDuration)
+
+	if err := l.store.Lock(attempts.Key, until); err != nil {
+		return err
+	}
+
+	l.audit(&models.LockoutEvent{
+		Event:       event,
+		Key:         attempts.Key,
+		UserID:      userID,
+		IP:          ip,
+		Failures:    attempts.Failures,
+		LockedUntil: &until,
+		CreatedAt:   now,
+	})
+
+	return nil
+}
+
+// audit logs event and stores it; a failure to store it does not undo the
+// lockout.
+func (l *Limiter) audit(event *models.LockoutEvent) {
+	log.Printf("Lockout: %s %s (user %q, ip %q, %d failure(s))", event.Event, event.Key, event.UserID, event.IP, event.Failures)
+
+	if err := l.store.Audit(event); err != nil {
+		log.Printf("Failed to store lockout audit event: %v", err)
+	}
+}
+
+func (l *Limiter) accountLimits() Limits {
+	return Limits{
+		MaxFailures: l.policy.MaxAccountFailures,
+		BaseDelay:   l.policy.BaseDelay,
+		MaxDelay:    l.policy.MaxDelay,
+	}
+}
+
+// wait tells how long a refused attempt on key has to wait, at least a
+// second, since the refusal may have been for attempts still in flight.
+func (l *Limiter) wait(key string, limits Limits, now time.Time) (time.Duration, error) {
+	attempts, err := l.store.Get(key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration = time.Second
+
+	if locked(attempts, now) {
+		wait = longer(wait, attempts.LockedUntil.Sub(now))
+	}
+
+	if attempts.Failures > 0 {
+		wait = longer(wait, attempts.LastFailureAt.Add(limits.Delay(attempts.Failures)).Sub(now))
+	}
+
+	return wait, nil
+}
+
+func locked(attempts *models.LoginAttempts, now time.Time) bool {
+	return attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil)
+}
+
+func longer(a time.Duration, b time.Duration) time.Duration {
+	if b > a {
+		return b
+	}
+
+	return a
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
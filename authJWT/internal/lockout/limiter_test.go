@@ -0,0 +1,192 @@
+package lockout
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func testPolicy() Policy {
+	return Policy{
+		MaxAccountFailures: 3,
+		MaxIPFailures:      10,
+		Window:             time.Minute,
+		LockoutDuration:    time.Minute,
+		BaseDelay:          0,
+		MaxDelay:           0,
+	}
+}
+
+func TestLimiterLocksAccountAfterMaxFailures(t *testing.T) {
+	var limiter *Limiter = NewLimiter(NewMemoryStore(), testPolicy())
+
+	for i := 0; i < 3; i++ {
+		attempt, wait, err := limiter.Begin("alice@example.com", "10.0.0.1")
+
+		if err != nil || attempt == nil {
+			t.Fatalf("attempt %d refused: wait %s, err %v", i+1, wait, err)
+		}
+
+		if err := limiter.Fail(attempt, ""); err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+	}
+
+	attempt, wait, err := limiter.Begin("Alice@Example.com ", "10.0.0.2")
+
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	if attempt != nil || wait < 59*time.Second {
+		t.Errorf("locked account: attempt %v, wait %s; want refused for about a minute", attempt, wait)
+	}
+
+	attempt, _, _ = limiter.Begin("bob@example.com", "10.0.0.1")
+
+	if attempt == nil {
+		t.Error("another account from the same address was refused")
+	}
+}
+
+func TestLimiterSucceedClearsAccount(t *testing.T) {
+	var limiter *Limiter = NewLimiter(NewMemoryStore(), testPolicy())
+
+	for i := 0; i < 2; i++ {
+		attempt, _, _ := limiter.Begin("alice@example.com", "10.0.0.1")
+		limiter.Fail(attempt, "")
+	}
+
+	attempt, _, _ := limiter.Begin("alice@example.com", "10.0.0.1")
+
+	if err := limiter.Succeed(attempt); err != nil {
+		t.Fatalf("Succeed: %v", err)
+	}
+
+	account, _ := limiter.store.Get(accountKey("alice@example.com"))
+
+	if account.Failures != 0 {
+		t.Errorf("account failures after success = %d, want 0", account.Failures)
+	}
+
+	address, _ := limiter.store.Get(ipKey("10.0.0.1"))
+
+	if address.Failures != 2 {
+		t.Errorf("address failures after success = %d, want 2", address.Failures)
+	}
+}
+
+func TestLimiterReleaseKeepsEarlierFailures(t *testing.T) {
+	var limiter *Limiter = NewLimiter(NewMemoryStore(), testPolicy())
+
+	attempt, _, _ := limiter.Begin("alice@example.com", "10.0.0.1")
+	limiter.Fail(attempt, "")
+
+	attempt, _, _ = limiter.Begin("alice@example.com", "10.0.0.1")
+
+	if err := limiter.Release(attempt); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+
+	account, _ := limiter.store.Get(accountKey("alice@example.com"))
+
+	if account.Failures != 1 {
+		t.Errorf("account failures after release = %d, want 1", account.Failures)
+	}
+}
+
+// Parallel guesses must not get past the limit because none of them has
+// failed yet when the others start.
+func TestLimiterReservesParallelAttempts(t *testing.T) {
+	var limiter *Limiter = NewLimiter(NewMemoryStore(), testPolicy())
+	var admitted int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			attempt, _, err := limiter.Begin("alice@example.com", "10.0.0.1")
+
+			if err == nil && attempt != nil {
+				mu.Lock()
+				admitted++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if admitted != 3 {
+		t.Errorf("%d parallel attempts admitted, want 3", admitted)
+	}
+}
+
+func TestLimiterDelay(t *testing.T) {
+	var policy Policy = testPolicy()
+	policy.BaseDelay = time.Minute
+	policy.MaxDelay = time.Hour
+
+	var limiter *Limiter = NewLimiter(NewMemoryStore(), policy)
+
+	attempt, _, _ := limiter.Begin("alice@example.com", "10.0.0.1")
+	limiter.Fail(attempt, "")
+
+	attempt, wait, err := limiter.Begin("alice@example.com", "10.0.0.1")
+
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	if attempt != nil || wait < 59*time.Second || wait > time.Minute {
+		t.Errorf("attempt right after a failure: attempt %v, wait %s; want refused for a minute", attempt, wait)
+	}
+}
+
+func TestLimitsDelay(t *testing.T) {
+	var limits Limits = Limits{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := limits.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	var throttle *Throttle = NewThrottle(NewMemoryStore(), "reset", 2, 3, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if wait, err := throttle.Allow("alice@example.com", "10.0.0.1"); err != nil || wait > 0 {
+			t.Fatalf("request %d refused: wait %s, err %v", i+1, wait, err)
+		}
+	}
+
+	if wait, _ := throttle.Allow("alice@example.com", "10.0.0.1"); wait < 59*time.Minute {
+		t.Errorf("third request for one email: wait %s, want about an hour", wait)
+	}
+
+	if wait, _ := throttle.Allow("bob@example.com", "10.0.0.1"); wait > 0 {
+		t.Errorf("request for another email refused, wait %s", wait)
+	}
+
+	if wait, _ := throttle.Allow("carol@example.com", "10.0.0.1"); wait == 0 {
+		t.Error("fourth request from one address allowed")
+	}
+}
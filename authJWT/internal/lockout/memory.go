@@ -0,0 +1,110 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+	"todo_api/internal/models"
+)
+
+// MemoryStore keeps failures in process. It suits a single instance;
+// counts are lost on restart and the audit log only goes to the
+// application log.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempts
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: map[string]models.LoginAttempts{}}
+}
+
+func (s *MemoryStore) Get(key string) (*models.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var attempts models.LoginAttempts = s.attempts[key]
+	attempts.Key = key
+
+	return &attempts, nil
+}
+
+func (s *MemoryStore) Reserve(key string, now time.Time, windowStart time.Time, limits Limits) (*models.LoginAttempts, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var attempts models.LoginAttempts = s.attempts[key]
+	attempts.Key = key
+
+	if locked(&attempts, now) {
+		return &attempts, false, nil
+	}
+
+	if attempts.LastFailureAt.Before(windowStart) || attempts.LockedUntil != nil {
+		attempts.Failures = 0
+		attempts.LockedUntil = nil
+	}
+
+	if attempts.Failures >= limits.MaxFailures || now.Before(attempts.LastFailureAt.Add(limits.Delay(attempts.Failures))) {
+		return &attempts, false, nil
+	}
+
+	attempts.Failures++
+	attempts.LastFailureAt = now
+	s.attempts[key] = attempts
+
+	return &attempts, true, nil
+}
+
+func (s *MemoryStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempts, ok := s.attempts[key]; ok && attempts.Failures > 0 {
+		attempts.Failures--
+		s.attempts[key] = attempts
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempts, ok := s.attempts[key]; ok {
+		attempts.LockedUntil = &until
+		s.attempts[key] = attempts
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *MemoryStore) DeleteStale(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	var now time.Time = time.Now()
+
+	for key, attempts := range s.attempts {
+		if attempts.LastFailureAt.Before(before) && (attempts.LockedUntil == nil || attempts.LockedUntil.Before(now)) {
+			delete(s.attempts, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// Audit keeps nothing; the Limiter logs every event anyway.
+func (s *MemoryStore) Audit(event *models.LockoutEvent) error {
+	return nil
+}
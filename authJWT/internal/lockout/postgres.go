@@ -0,0 +1,47 @@
+package lockout
+
+import (
+	"time"
+	"todo_api/internal/models"
+	"todo_api/internal/repository"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps failures in the login_attempts table, shared by all
+// replicas, and the audit log in lockout_audit_log.
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+func (s *PostgresStore) Get(key string) (*models.LoginAttempts, error) {
+	return repository.GetLoginAttempts(s.pool, key)
+}
+
+func (s *PostgresStore) Reserve(key string, now time.Time, windowStart time.Time, limits Limits) (*models.LoginAttempts, bool, error) {
+	return repository.ReserveLoginAttempt(s.pool, key, now, windowStart, limits.MaxFailures, limits.BaseDelay, limits.MaxDelay)
+}
+
+func (s *PostgresStore) Release(key string) error {
+	return repository.ReleaseLoginAttempt(s.pool, key)
+}
+
+func (s *PostgresStore) Lock(key string, until time.Time) error {
+	return repository.LockLogin(s.pool, key, until)
+}
+
+func (s *PostgresStore) Reset(key string) error {
+	return repository.ResetLoginAttempts(s.pool, key)
+}
+
+func (s *PostgresStore) DeleteStale(before time.Time) (int64, error) {
+	return repository.DeleteStaleLoginAttempts(s.pool, before)
+}
+
+func (s *PostgresStore) Audit(event *models.LockoutEvent) error {
+	return repository.InsertLockoutEvent(s.pool, event)
+}
@@ -0,0 +1,89 @@
+// Package lockout slows down and then stops password guessing. Failed
+// logins are counted per account and per client IP address; each account
+// failure doubles the wait before the next attempt, and too many failures
+// lock the account or the address for a while. An attempt is counted
+// before the password is checked and taken back if it succeeds.
+package lockout
+
+import (
+	"context"
+	"log"
+	"time"
+	"todo_api/internal/models"
+)
+
+// Store keeps failure counts and the audit log.
+type Store interface {
+	// Get returns the failures of key, with a zero count if there are
+	// none.
+	Get(key string) (*models.LoginAttempts, error)
+	// Reserve atomically counts an attempt on key at now, restarting the
+	// count if the previous failure was before windowStart or a lockout
+	// has ended. It reserves
+	// nothing and returns false if key is locked, has reached
+	// limits.MaxFailures, or its last failure was less than
+	// limits.Delay(failures) ago.
+	Reserve(key string, now time.Time, windowStart time.Time, limits Limits) (*models.LoginAttempts, bool, error)
+	// Release takes back one reserved attempt of key.
+	Release(key string) error
+	Lock(key string, until time.Time) error
+	// Reset forgets the failures and any lockout of key.
+	Reset(key string) error
+	// DeleteStale forgets unlocked keys without failures since before and
+	// returns how many it removed.
+	DeleteStale(before time.Time) (int64, error)
+	Audit(event *models.LockoutEvent) error
+}
+
+// Limits are what Reserve enforces for one key.
+type Limits struct {
+	MaxFailures int
+	// After the nth failure the next attempt must wait BaseDelay *
+	// 2^(n-1), at most MaxDelay. Zero means no delay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Delay is the wait after failures failures.
+func (l Limits) Delay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+
+	var delay time.Duration = l.BaseDelay
+
+	for i := 1; i < failures && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > l.MaxDelay {
+		return l.MaxDelay
+	}
+
+	return delay
+}
+
+// RunCleanup deletes keys idle for longer than maxAge every interval until
+// ctx is done.
+func RunCleanup(ctx context.Context, store Store, interval time.Duration, maxAge time.Duration) {
+	var ticker *time.Ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := store.DeleteStale(time.Now().Add(-maxAge))
+
+			if err != nil {
+				log.Printf("Failed to clean up login attempts: %v", err)
+				continue
+			}
+
+			if deleted > 0 {
+				log.Printf("Cleaned up %d stale login attempt record(s)", deleted)
+			}
+		}
+	}
+}
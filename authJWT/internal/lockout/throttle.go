@@ -0,0 +1,71 @@
+package lockout
+
+import (
+	"log"
+	"time"
+)
+
+// Throttle limits how often something may be requested per email and per
+// client address, e.g. mailing password reset links. It counts in the same
+// Store as failed logins, under keys prefixed with its name.
+type Throttle struct {
+	store    Store
+	name     string
+	perEmail int
+	perIP    int
+	window   time.Duration
+}
+
+// NewThrottle allows perEmail requests for one email and perIP requests
+// from one address within window.
+func NewThrottle(store Store, name string, perEmail int, perIP int, window time.Duration) *Throttle {
+	return &Throttle{store: store, name: name, perEmail: perEmail, perIP: perIP, window: window}
+}
+
+// Allow counts a request for email from ip. If either limit is reached it
+// counts nothing and returns how long to wait.
+func (t *Throttle) Allow(email string, ip string) (time.Duration, error) {
+	var now time.Time = time.Now()
+	var windowStart time.Time = now.Add(-t.window)
+	var addressKey string = t.name + ":" + ipKey(ip)
+	var emailKey string = t.name + ":" + accountKey(email)
+
+	_, ok, err := t.store.Reserve(addressKey, now, windowStart, Limits{MaxFailures: t.perIP})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		return t.wait(addressKey, now)
+	}
+
+	_, ok, err = t.store.Reserve(emailKey, now, windowStart, Limits{MaxFailures: t.perEmail})
+
+	if err != nil || !ok {
+		if err := t.store.Release(addressKey); err != nil {
+			log.Printf("Failed to release %s: %v", addressKey, err)
+		}
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		return t.wait(emailKey, now)
+	}
+
+	return 0, nil
+}
+
+// wait is how long until the count of key restarts, at least a second.
+func (t *Throttle) wait(key string, now time.Time) (time.Duration, error) {
+	attempts, err := t.store.Get(key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return longer(time.Second, attempts.LastFailureAt.Add(t.window).Sub(now)), nil
+}
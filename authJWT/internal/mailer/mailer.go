@@ -0,0 +1,16 @@
+// Package mailer sends the emails of the auth flows, such as address
+// verification.
+package mailer
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. SMTPMailer sends real mail; LogMailer writes
+// messages to the log or a file for local testing.
+type Mailer interface {
+	Send(msg Message) error
+}
@@ -5,13 +5,18 @@ import (
 	"net/http"
 	"strings"
 	"time"
-	"todo_api/internal/config"
+	"todo_api/internal/keys"
+	"todo_api/internal/revocation"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
 )
 
-func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+// AuthMiddleware accepts a bearer token signed by a key of keySet, picked by
+// the token's kid header, that names issuer and audience and is unexpired
+// and not revoked. The token's
+// roles are put in the context for RequirePermission.
+func AuthMiddleware(keySet *keys.KeySet, revocations revocation.Store, issuer string, audience string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 
@@ -30,10 +35,13 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 		}
 
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			kid, ok := token.Header["kid"].(string)
+
+			if !ok {
+				return nil, fmt.Errorf("token has no kid header")
 			}
-			return []byte(cfg.JWTSecret), nil
+
+			return keySet.VerificationKey(kid, token.Method.Alg(), time.Now())
 		})
 
 		if err != nil || !token.Valid {
@@ -50,6 +58,12 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
+		if !claims.VerifyIssuer(issuer, true) || !claims.VerifyAudience(audience, true) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token Claims"})
+			c.Abort()
+			return
+		}
+
 		userID, ok := claims["user_id"].(string)
 
 		if !ok {
@@ -58,17 +72,63 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		if exp, ok := claims["exp"].(float64); ok {
-			expirationTime := time.Unix(int64(exp), 0)
+		exp, ok := claims["exp"].(float64)
+
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token Claims"})
+			c.Abort()
+			return
+		}
+
+		expirationTime := time.Unix(int64(exp), 0)
+
+		if time.Now().After(expirationTime) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has expired"})
+			c.Abort()
+			return
+		}
+
+		// tokens without a jti predate revocation and are refused; a missing
+		// gen is generation 0, so any logout-all since rejects the token
+		jti, ok := claims["jti"].(string)
+
+		if !ok || jti == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token Claims"})
+			c.Abort()
+			return
+		}
+
+		generation, _ := claims["gen"].(float64)
+
+		revoked, err := revocation.IsTokenRevoked(revocations, jti, userID, int64(generation))
+
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify token"})
+			c.Abort()
+			return
+		}
+
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
+
+		// tokens issued before roles existed carry none
+		var roles []string = []string{}
 
-			if time.Now().After(expirationTime) {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has expired"})
-				c.Abort()
-				return
+		if claimed, ok := claims["roles"].([]interface{}); ok {
+			for _, role := range claimed {
+				if name, ok := role.(string); ok {
+					roles = append(roles, name)
+				}
 			}
 		}
 
 		c.Set("user_id", userID)
+		c.Set("roles", roles)
+		c.Set("jti", jti)
+		c.Set("token_expires_at", expirationTime)
 		c.Next()
 	}
 }
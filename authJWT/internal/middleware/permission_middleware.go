@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"todo_api/internal/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission lets a request through only if the roles of its token
+// grant all of permissions. It must run after AuthMiddleware.
+func RequirePermission(policy *rbac.Policy, permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roles := c.GetStringSlice("roles")
+
+		allowed, err := policy.Allows(roles, permissions...)
+
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to check permissions"})
+			c.Abort()
+			return
+		}
+
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// LoginAttempts counts recent failed logins for an account or an IP
+// address.
+type LoginAttempts struct {
+	Key           string     `json:"key" db:"key"`
+	Failures      int        `json:"failures" db:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at" db:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until" db:"locked_until"`
+}
+
+// LockoutEvent is an entry of the lockout audit log.
+type LockoutEvent struct {
+	Event       string     `json:"event" db:"event"`
+	Key         string     `json:"key" db:"key"`
+	UserID      string     `json:"user_id,omitempty" db:"user_id"`
+	ActorID     string     `json:"actor_id,omitempty" db:"actor_id"`
+	IP          string     `json:"ip,omitempty" db:"ip"`
+	Failures    int        `json:"failures" db:"failures"`
+	LockedUntil *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
@@ -10,3 +10,25 @@ type Todo struct {
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 	UserID    string    `json:"user_id" db:"user_id"`
 }
+
+// TodoFilter narrows and orders a todo listing. Nil or zero fields are not
+// applied; the From bounds are inclusive and the To bounds exclusive.
+type TodoFilter struct {
+	Completed   *bool
+	Search      string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	UpdatedFrom *time.Time
+	UpdatedTo   *time.Time
+	SortBy      string
+	SortDesc    bool
+	Limit       int
+	Cursor      string
+}
+
+// TodoPage is one page of a todo listing. NextCursor is empty on the last
+// page.
+type TodoPage struct {
+	Data       []Todo `json:"data"`
+	NextCursor string `json:"next_cursor"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// TOTP is the two-factor state of a user. Secret is set from enrollment
+// on; Enabled only once a code from it has been confirmed.
+type TOTP struct {
+	Secret       *string `db:"totp_secret"`
+	Enabled      bool    `db:"totp_enabled"`
+	LastUsedStep *int64  `db:"totp_last_used_step"`
+}
@@ -3,9 +3,12 @@ package models
 import "time"
 
 type User struct {
-	ID        string    `json:"id" db:"id"`
-	Email     string    `json:"email" db:"email"`
-	Password  string    `json:"-" db:"password"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID            string    `json:"id" db:"id"`
+	Email         string    `json:"email" db:"email"`
+	Password      string    `json:"-" db:"password"`
+	EmailVerified bool      `json:"email_verified" db:"email_verified"`
+	TOTPEnabled   bool      `json:"totp_enabled" db:"totp_enabled"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+	Roles         []string  `json:"roles,omitempty" db:"-"`
 }
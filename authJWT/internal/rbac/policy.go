@@ -0,0 +1,86 @@
+// Package rbac maps the roles carried in access tokens to permissions.
+package rbac
+
+import (
+	"sync"
+	"time"
+	"todo_api/internal/repository"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Permission names, as seeded by the RBAC migration.
+const (
+	PermissionUsersRead      = "users:read"
+	PermissionUserRolesWrite = "users:roles:write"
+	PermissionUsersUnlock    = "users:unlock"
+	PermissionTodosReadAny   = "todos:read:any"
+	PermissionTodosWriteAny  = "todos:write:any"
+)
+
+// Policy answers which permissions a set of roles grants. The role to
+// permission table is loaded from Postgres and reloaded once it is older
+// than ttl, so changes to role_permissions apply without a restart.
+type Policy struct {
+	pool *pgxpool.Pool
+	ttl  time.Duration
+
+	mu       sync.Mutex
+	roles    map[string][]string
+	loadedAt time.Time
+}
+
+func NewPolicy(pool *pgxpool.Pool, ttl time.Duration) *Policy {
+	return &Policy{pool: pool, ttl: ttl}
+}
+
+// Allows reports whether any of roles grants every one of permissions.
+// Permissions granted by different roles add up.
+func (p *Policy) Allows(roles []string, permissions ...string) (bool, error) {
+	table, err := p.table()
+
+	if err != nil {
+		return false, err
+	}
+
+	var granted map[string]bool = map[string]bool{}
+
+	for _, role := range roles {
+		for _, permission := range table[role] {
+			granted[permission] = true
+		}
+	}
+
+	for _, permission := range permissions {
+		if !granted[permission] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (p *Policy) table() (map[string][]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.roles != nil && time.Since(p.loadedAt) < p.ttl {
+		return p.roles, nil
+	}
+
+	roles, err := repository.GetRolePermissions(p.pool)
+
+	if err != nil {
+		// keep answering from the last table while the database is away
+		if p.roles != nil {
+			return p.roles, nil
+		}
+
+		return nil, err
+	}
+
+	p.roles = roles
+	p.loadedAt = time.Now()
+
+	return roles, nil
+}
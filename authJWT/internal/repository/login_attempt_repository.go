@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"todo_api/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// GetLoginAttempts returns the failed logins of key, or a zero count if
+// there are none.
+func GetLoginAttempts(pool *pgxpool.Pool, key string) (*models.LoginAttempts, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		SELECT key, failures, last_failure_at, locked_until
+		FROM login_attempts
+		WHERE key = $1
+	`
+
+	var attempts models.LoginAttempts = models.LoginAttempts{Key: key}
+
+	var err error = pool.QueryRow(ctx, query, key).Scan(
+		&attempts.Key,
+		&attempts.Failures,
+		&attempts.LastFailureAt,
+		&attempts.LockedUntil,
+	)
+
+	if err == pgx.ErrNoRows {
+		return &attempts, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempts, nil
+}
+
+// ReserveLoginAttempt counts an attempt of key at now in one statement,
+// so concurrent attempts cannot all slip under the limits. Failures before
+// windowStart, or before an expired lockout, are forgotten first. Nothing is counted, and false returned,
+// if key is locked, has maxFailures failures, or its last failure was
+// less than baseDelay * 2^(failures-1), at most maxDelay, ago.
+func ReserveLoginAttempt(pool *pgxpool.Pool, key string, now time.Time, windowStart time.Time, maxFailures int, baseDelay time.Duration, maxDelay time.Duration) (*models.LoginAttempts, bool, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempts.last_failure_at < $3 OR login_attempts.locked_until IS NOT NULL THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = $2,
+			locked_until = NULL
+		WHERE (login_attempts.locked_until IS NULL OR login_attempts.locked_until <= $2)
+			AND (
+				login_attempts.last_failure_at < $3
+				OR login_attempts.locked_until IS NOT NULL
+				OR login_attempts.failures = 0
+				OR (
+					login_attempts.failures < $4
+					AND login_attempts.last_failure_at
+						+ LEAST($5 * power(2, LEAST(login_attempts.failures - 1, 30)), $6) * interval '1 microsecond' <= $2
+				)
+			)
+		RETURNING key, failures, last_failure_at, locked_until
+	`
+
+	var attempts models.LoginAttempts
+
+	var err error = pool.QueryRow(ctx, query, key, now, windowStart, maxFailures, baseDelay.Microseconds(), maxDelay.Microseconds()).Scan(
+		&attempts.Key,
+		&attempts.Failures,
+		&attempts.LastFailureAt,
+		&attempts.LockedUntil,
+	)
+
+	if err == pgx.ErrNoRows {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &attempts, true, nil
+}
+
+// ReleaseLoginAttempt takes back one attempt counted by
+// ReserveLoginAttempt.
+func ReleaseLoginAttempt(pool *pgxpool.Pool, key string) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		UPDATE login_attempts SET failures = GREATEST(failures - 1, 0)
+		WHERE key = $1
+	`
+
+	var _, err = pool.Exec(ctx, query, key)
+
+	return err
+}
+
+func LockLogin(pool *pgxpool.Pool, key string, until time.Time) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		UPDATE login_attempts SET locked_until = $2
+		WHERE key = $1
+	`
+
+	var _, err = pool.Exec(ctx, query, key, until)
+
+	return err
+}
+
+// ResetLoginAttempts forgets the failures and any lockout of key.
+func ResetLoginAttempts(pool *pgxpool.Pool, key string) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var _, err = pool.Exec(ctx, `DELETE FROM login_attempts WHERE key = $1`, key)
+
+	return err
+}
+
+// DeleteStaleLoginAttempts removes keys whose last failure is before
+// before and that are not locked any more.
+func DeleteStaleLoginAttempts(pool *pgxpool.Pool, before time.Time) (int64, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		DELETE FROM login_attempts
+		WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < NOW())
+	`
+
+	commandTag, err := pool.Exec(ctx, query, before)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return commandTag.RowsAffected(), nil
+}
+
+func InsertLockoutEvent(pool *pgxpool.Pool, event *models.LockoutEvent) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		INSERT INTO lockout_audit_log (event, key, user_id, actor_id, ip, failures, locked_until, created_at)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, NULLIF($4, '')::uuid, NULLIF($5, ''), $6, $7, $8)
+	`
+
+	var _, err = pool.Exec(ctx, query,
+		event.Event,
+		event.Key,
+		event.UserID,
+		event.ActorID,
+		event.IP,
+		event.Failures,
+		event.LockedUntil,
+		event.CreatedAt,
+	)
+
+	return err
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"todo_api/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func CreateRefreshToken(pool *pgxpool.Pool, token *models.RefreshToken) (*models.RefreshToken, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	var err error = pool.QueryRow(ctx, query, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt).Scan(
+		&token.ID,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func GetRefreshTokenByHash(pool *pgxpool.Pool, tokenHash string) (*models.RefreshToken, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		SELECT id, user_id, family_id, token_hash, expires_at, created_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+
+	var token models.RefreshToken
+
+	var err error = pool.QueryRow(ctx, query, tokenHash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// RotateRefreshToken marks the token with usedID as used and stores next in
+// the same transaction. It returns pgx.ErrNoRows if the old token was used
+// or revoked in the meantime, which callers treat as reuse.
+func RotateRefreshToken(pool *pgxpool.Pool, usedID string, next *models.RefreshToken) (*models.RefreshToken, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var tx, err = pool.Begin(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	var markUsed string = `
+		UPDATE refresh_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL
+	`
+
+	commandTag, err := tx.Exec(ctx, markUsed, usedID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if commandTag.RowsAffected() == 0 {
+		return nil, pgx.ErrNoRows
+	}
+
+	var insert string = `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	err = tx.QueryRow(ctx, insert, next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt).Scan(
+		&next.ID,
+		&next.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return next, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token of a family that is not
+// revoked yet.
+func RevokeRefreshTokenFamily(pool *pgxpool.Pool, familyID string) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`
+
+	var _, err = pool.Exec(ctx, query, familyID)
+
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user.
+func RevokeUserRefreshTokens(pool *pgxpool.Pool, userID string) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+
+	var _, err = pool.Exec(ctx, query, userID)
+
+	return err
+}
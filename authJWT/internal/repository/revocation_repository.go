@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func InsertRevokedToken(pool *pgxpool.Pool, jti string, userID string, expiresAt time.Time) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING
+	`
+
+	var _, err = pool.Exec(ctx, query, jti, userID, expiresAt)
+
+	return err
+}
+
+func IsTokenRevoked(pool *pgxpool.Pool, jti string) (bool, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+	`
+
+	var revoked bool
+
+	var err error = pool.QueryRow(ctx, query, jti).Scan(&revoked)
+
+	return revoked, err
+}
+
+// IncrementTokenGeneration invalidates every access token the user holds.
+func IncrementTokenGeneration(pool *pgxpool.Pool, userID string) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		UPDATE users
+		SET token_generation = token_generation + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`
+
+	var _, err = pool.Exec(ctx, query, userID)
+
+	return err
+}
+
+// GetTokenGeneration returns 0 if the user never logged out everywhere
+// (or does not exist).
+func GetTokenGeneration(pool *pgxpool.Pool, userID string) (int64, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		SELECT token_generation
+		FROM users
+		WHERE id = $1
+	`
+
+	var generation int64
+
+	var err error = pool.QueryRow(ctx, query, userID).Scan(&generation)
+
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+
+	return generation, err
+}
+
+func DeleteExpiredRevokedTokens(pool *pgxpool.Pool) (int64, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var query string = `
+		DELETE FROM revoked_tokens
+		WHERE expires_at < CURRENT_TIMESTAMP
+	`
+
+	var commandTag, err = pool.Exec(ctx, query)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return commandTag.RowsAffected(), nil
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"todo_api/internal/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+func GetUserRoles(pool *pgxpool.Pool, userID string) ([]string, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		SELECT r.name
+		FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = $1
+		ORDER BY r.name
+	`
+
+	var rows, err = pool.Query(ctx, query, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var roles []string = []string{}
+
+	for rows.Next() {
+		var role string
+
+		if err = rows.Scan(&role); err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// SetUserRoles replaces the roles of a user. Unknown role names are an
+// error and leave the roles unchanged.
+func SetUserRoles(pool *pgxpool.Pool, userID string, roles []string) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var unique map[string]bool = map[string]bool{}
+
+	for _, role := range roles {
+		unique[role] = true
+	}
+
+	var tx, err = pool.Begin(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM user_roles WHERE user_id = $1`, userID)
+
+	if err != nil {
+		return err
+	}
+
+	var insert string = `
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = ANY($2)
+	`
+
+	commandTag, err := tx.Exec(ctx, insert, userID, roles)
+
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() != int64(len(unique)) {
+		return ErrUnknownRole
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetRolePermissions maps every role to the names of its permissions.
+func GetRolePermissions(pool *pgxpool.Pool) (map[string][]string, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		SELECT r.name, p.name
+		FROM role_permissions rp
+		JOIN roles r ON r.id = rp.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+	`
+
+	var rows, err = pool.Query(ctx, query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var permissions map[string][]string = map[string][]string{}
+
+	for rows.Next() {
+		var role, permission string
+
+		if err = rows.Scan(&role, &permission); err != nil {
+			return nil, err
+		}
+
+		permissions[role] = append(permissions[role], permission)
+	}
+
+	return permissions, rows.Err()
+}
+
+// ListUsers returns a page of users with their roles, oldest first.
+func ListUsers(pool *pgxpool.Pool, limit int, offset int) ([]models.User, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		SELECT u.id, u.email, u.email_verified, u.totp_enabled, u.created_at, u.updated_at,
+			COALESCE(array_agg(r.name ORDER BY r.name) FILTER (WHERE r.name IS NOT NULL), '{}')
+		FROM users u
+		LEFT JOIN user_roles ur ON ur.user_id = u.id
+		LEFT JOIN roles r ON r.id = ur.role_id
+		GROUP BY u.id
+		ORDER BY u.created_at, u.id
+		LIMIT $1 OFFSET $2
+	`
+
+	var rows, err = pool.Query(ctx, query, limit, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var users []models.User = []models.User{}
+
+	for rows.Next() {
+		var user models.User
+
+		err = rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.EmailVerified,
+			&user.TOTPEnabled,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.Roles,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"testing"
+	"time"
+	"todo_api/internal/models"
+)
+
+func TestTodoCursorRoundTrip(t *testing.T) {
+	var created time.Time = time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)
+	var last models.Todo = models.Todo{ID: 42, Title: "buy milk", CreatedAt: created, UpdatedAt: created.Add(time.Hour)}
+
+	tests := []struct {
+		sortBy    string
+		desc      bool
+		wantValue string
+	}{
+		{"created_at", true, "2024-05-01T12:30:00.123456789Z"},
+		{"updated_at", false, "2024-05-01T13:30:00.123456789Z"},
+		{"title", false, "buy milk"},
+		{"id", true, "42"},
+	}
+
+	for _, tt := range tests {
+		var filter models.TodoFilter = models.TodoFilter{SortBy: tt.sortBy, SortDesc: tt.desc}
+
+		cursor, err := decodeTodoCursor(encodeTodoCursor(filter, last))
+
+		if err != nil {
+			t.Fatalf("%s: decode: %v", tt.sortBy, err)
+		}
+
+		var want todoCursor = todoCursor{Sort: tt.sortBy, Desc: tt.desc, Value: tt.wantValue, ID: 42}
+
+		if cursor != want {
+			t.Errorf("%s: cursor = %+v, want %+v", tt.sortBy, cursor, want)
+		}
+	}
+}
+
+func TestDecodeTodoCursorRejectsGarbage(t *testing.T) {
+	for _, s := range []string{"not base64!", "bm90IGpzb24"} {
+		if _, err := decodeTodoCursor(s); err == nil {
+			t.Errorf("decodeTodoCursor(%q) succeeded", s)
+		}
+	}
+}
@@ -2,7 +2,12 @@ package repository
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 	"todo_api/internal/models"
 
@@ -39,20 +44,100 @@ func CreateTodo(pool *pgxpool.Pool, title string, completed bool, userID string)
 	return &todo, nil
 }
 
-func GetAllTodos(pool *pgxpool.Pool, userID string) ([]models.Todo, error) {
+// ErrInvalidCursor means the cursor was not issued for this listing.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// todoSortColumns maps the sortable fields to their SQL type, used to cast
+// the cursor value.
+var todoSortColumns = map[string]string{
+	"created_at": "timestamp",
+	"updated_at": "timestamp",
+	"title":      "text",
+	"id":         "integer",
+}
+
+// todoCursor is the position after the last todo of a page: the value of
+// the sort column and the id, which breaks ties.
+type todoCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func GetAllTodos(pool *pgxpool.Pool, userID string, filter models.TodoFilter) (*models.TodoPage, error) {
 	var ctx context.Context
 	var cancel context.CancelFunc
 	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var query string = `
+	if filter.SortBy == "" {
+		filter.SortBy = "created_at"
+		filter.SortDesc = true
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = 20
+	}
+
+	var sqlType, ok = todoSortColumns[filter.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("cannot sort todos by %q", filter.SortBy)
+	}
+
+	var conditions []string = []string{"user_id = $1"}
+	var args []interface{} = []interface{}{userID}
+	var arg = func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.Completed != nil {
+		conditions = append(conditions, "completed = "+arg(*filter.Completed))
+	}
+	if filter.Search != "" {
+		conditions = append(conditions, "to_tsvector('simple', title) @@ websearch_to_tsquery('simple', "+arg(filter.Search)+")")
+	}
+	if filter.CreatedFrom != nil {
+		conditions = append(conditions, "created_at >= "+arg(filter.CreatedFrom.UTC()))
+	}
+	if filter.CreatedTo != nil {
+		conditions = append(conditions, "created_at < "+arg(filter.CreatedTo.UTC()))
+	}
+	if filter.UpdatedFrom != nil {
+		conditions = append(conditions, "updated_at >= "+arg(filter.UpdatedFrom.UTC()))
+	}
+	if filter.UpdatedTo != nil {
+		conditions = append(conditions, "updated_at < "+arg(filter.UpdatedTo.UTC()))
+	}
+
+	var direction string = "ASC"
+	var comparison string = ">"
+	if filter.SortDesc {
+		direction = "DESC"
+		comparison = "<"
+	}
+
+	if filter.Cursor != "" {
+		var cursor, err = decodeTodoCursor(filter.Cursor)
+		// a cursor only makes sense with the sort order it was issued for
+		if err != nil || cursor.Sort != filter.SortBy || cursor.Desc != filter.SortDesc {
+			return nil, ErrInvalidCursor
+		}
+
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s::%s, %s)",
+			filter.SortBy, comparison, arg(cursor.Value), sqlType, arg(cursor.ID)))
+	}
+
+	// fetch one extra row to know whether there is a next page
+	var query string = fmt.Sprintf(`
 		SELECT id, title, completed, created_at, updated_at, user_id
 		FROM todos
-		WHERE user_id = $1
-		ORDER BY created_at DESC
-	`
+		WHERE %s
+		ORDER BY %s %s, id %s
+		LIMIT %s
+	`, strings.Join(conditions, " AND "), filter.SortBy, direction, direction, arg(filter.Limit+1))
 
-	var rows, err = pool.Query(ctx, query, userID)
+	var rows, err = pool.Query(ctx, query, args...)
 
 	if err != nil {
 		return nil, err
@@ -85,7 +170,44 @@ func GetAllTodos(pool *pgxpool.Pool, userID string) ([]models.Todo, error) {
 		return nil, err
 	}
 
-	return todos, nil
+	var page models.TodoPage = models.TodoPage{Data: todos}
+
+	if len(todos) > filter.Limit {
+		page.Data = todos[:filter.Limit]
+		page.NextCursor = encodeTodoCursor(filter, page.Data[len(page.Data)-1])
+	}
+
+	return &page, nil
+}
+
+func encodeTodoCursor(filter models.TodoFilter, last models.Todo) string {
+	var cursor todoCursor = todoCursor{Sort: filter.SortBy, Desc: filter.SortDesc, ID: last.ID}
+
+	switch filter.SortBy {
+	case "created_at":
+		cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		cursor.Value = last.UpdatedAt.Format(time.RFC3339Nano)
+	case "title":
+		cursor.Value = last.Title
+	case "id":
+		cursor.Value = strconv.Itoa(last.ID)
+	}
+
+	var data, _ = json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeTodoCursor(s string) (todoCursor, error) {
+	var cursor todoCursor
+
+	var data, err = base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
 }
 
 func GetToDoByID(pool *pgxpool.Pool, id int, userID string) (*models.Todo, error) {
@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"todo_api/internal/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func GetTOTP(pool *pgxpool.Pool, userID string) (*models.TOTP, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		SELECT totp_secret, totp_enabled, totp_last_used_step
+		FROM users
+		WHERE id = $1
+	`
+
+	var totp models.TOTP
+
+	var err error = pool.QueryRow(ctx, query, userID).Scan(
+		&totp.Secret,
+		&totp.Enabled,
+		&totp.LastUsedStep,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &totp, nil
+}
+
+// SetPendingTOTPSecret stores a new secret for a user who has not enabled
+// two-factor authentication. It reports false if they already have.
+func SetPendingTOTPSecret(pool *pgxpool.Pool, userID string, secret string) (bool, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		UPDATE users SET totp_secret = $2, totp_last_used_step = NULL, updated_at = NOW()
+		WHERE id = $1 AND NOT totp_enabled
+	`
+
+	commandTag, err := pool.Exec(ctx, query, userID, secret)
+
+	if err != nil {
+		return false, err
+	}
+
+	return commandTag.RowsAffected() == 1, nil
+}
+
+// ReplaceTOTPSecret swaps the stored secret old of a user for new, e.g. to
+// encrypt it. It does nothing if the secret changed in the meantime.
+func ReplaceTOTPSecret(pool *pgxpool.Pool, userID string, old string, new string) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		UPDATE users SET totp_secret = $3
+		WHERE id = $1 AND totp_secret = $2
+	`
+
+	var _, err = pool.Exec(ctx, query, userID, old, new)
+
+	return err
+}
+
+// EnableTOTP turns two-factor authentication on, records step as used and
+// replaces the user's recovery codes with codeHashes.
+func EnableTOTP(pool *pgxpool.Pool, userID string, step int64, codeHashes []string) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var tx, err = pool.Begin(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users SET totp_enabled = TRUE, totp_last_used_step = $2, updated_at = NOW()
+		WHERE id = $1
+	`, userID, step)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO recovery_codes (user_id, code_hash)
+		SELECT $1, unnest($2::text[])
+	`, userID, codeHashes)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseTOTPStep records step as used unless it is not newer than the last
+// used one, in which case the code is a replay and it reports false.
+func UseTOTPStep(pool *pgxpool.Pool, userID string, step int64) (bool, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		UPDATE users SET totp_last_used_step = $2
+		WHERE id = $1 AND (totp_last_used_step IS NULL OR totp_last_used_step < $2)
+	`
+
+	commandTag, err := pool.Exec(ctx, query, userID, step)
+
+	if err != nil {
+		return false, err
+	}
+
+	return commandTag.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports
+// whether there was one.
+func UseRecoveryCode(pool *pgxpool.Pool, userID string, codeHash string) (bool, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		UPDATE recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	commandTag, err := pool.Exec(ctx, query, userID, codeHash)
+
+	if err != nil {
+		return false, err
+	}
+
+	return commandTag.RowsAffected() == 1, nil
+}
+
+// DisableTOTP turns two-factor authentication off and forgets the secret
+// and recovery codes.
+func DisableTOTP(pool *pgxpool.Pool, userID string) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var tx, err = pool.Begin(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_used_step = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, userID)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
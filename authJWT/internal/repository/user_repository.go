@@ -14,15 +14,26 @@ func CreateUser(pool *pgxpool.Pool, user *models.User) (*models.User, error) {
 	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// new users get the "user" role in the same statement
 	var query string = `
-		INSERT INTO users (email, password)
-		VALUES ($1, $2)
-		RETURNING id, email, created_at, updated_at
+		WITH new_user AS (
+			INSERT INTO users (email, password)
+			VALUES ($1, $2)
+			RETURNING id, email, email_verified, totp_enabled, created_at, updated_at
+		), default_role AS (
+			INSERT INTO user_roles (user_id, role_id)
+			SELECT new_user.id, roles.id
+			FROM new_user, roles
+			WHERE roles.name = 'user'
+		)
+		SELECT id, email, email_verified, totp_enabled, created_at, updated_at FROM new_user
 	`
 
 	err := pool.QueryRow(ctx, query, user.Email, user.Password).Scan(
 		&user.ID,
 		&user.Email,
+		&user.EmailVerified,
+		&user.TOTPEnabled,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -41,7 +52,7 @@ func GetUserByEmail(pool *pgxpool.Pool, email string) (*models.User, error) {
 	defer cancel()
 
 	var query string = `
-		SELECT id, email, password, created_at, updated_at
+		SELECT id, email, password, email_verified, totp_enabled, created_at, updated_at
 		FROM users
 		WHERE email = $1
 	`
@@ -51,6 +62,8 @@ func GetUserByEmail(pool *pgxpool.Pool, email string) (*models.User, error) {
 		&user.ID,
 		&user.Email,
 		&user.Password,
+		&user.EmailVerified,
+		&user.TOTPEnabled,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -69,7 +82,7 @@ func GetUserByID(pool *pgxpool.Pool, id string) (*models.User, error) {
 	defer cancel()
 
 	var query string = `
-		SELECT id, email, password, created_at, updated_at
+		SELECT id, email, password, email_verified, totp_enabled, created_at, updated_at
 		FROM users
 		WHERE id = $1
 	`
@@ -79,6 +92,8 @@ func GetUserByID(pool *pgxpool.Pool, id string) (*models.User, error) {
 		&user.ID,
 		&user.Email,
 		&user.Password,
+		&user.EmailVerified,
+		&user.TOTPEnabled,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -89,3 +104,19 @@ func GetUserByID(pool *pgxpool.Pool, id string) (*models.User, error) {
 
 	return &user, nil
 }
+
+func UpdatePassword(pool *pgxpool.Pool, userID string, passwordHash string) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		UPDATE users SET password = $2, updated_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err := pool.Exec(ctx, query, userID, passwordHash)
+
+	return err
+}
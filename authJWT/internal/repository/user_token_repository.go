@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Purposes of the rows in user_tokens.
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+	TokenPurposeMFAChallenge  = "mfa_challenge"
+)
+
+// CreateUserToken stores the hash of a token mailed to a user and retires
+// any unused token of the same purpose, so only the newest one works.
+func CreateUserToken(pool *pgxpool.Pool, userID string, purpose string, tokenHash string, expiresAt time.Time) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var tx, err = pool.Begin(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE user_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, purpose)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, purpose, tokenHash, expiresAt)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// consumeUserToken marks an unused, unexpired token as used and returns its
+// user. It returns pgx.ErrNoRows for any other token.
+func consumeUserToken(ctx context.Context, tx pgx.Tx, purpose string, tokenHash string) (string, error) {
+	var query string = `
+		UPDATE user_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+	`
+
+	var userID string
+	var err error = tx.QueryRow(ctx, query, tokenHash, purpose).Scan(&userID)
+
+	return userID, err
+}
+
+// UserTokenValid reports whether a token is unused and unexpired, without
+// using it up.
+func UserTokenValid(pool *pgxpool.Pool, purpose string, tokenHash string) (bool, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var query string = `
+		SELECT EXISTS (
+			SELECT 1 FROM user_tokens
+			WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		)
+	`
+
+	var valid bool
+
+	var err error = pool.QueryRow(ctx, query, tokenHash, purpose).Scan(&valid)
+
+	return valid, err
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns
+// its user. It returns pgx.ErrNoRows for any other token.
+func ConsumeUserToken(pool *pgxpool.Pool, purpose string, tokenHash string) (string, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var tx, err = pool.Begin(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer tx.Rollback(ctx)
+
+	userID, err := consumeUserToken(ctx, tx, purpose, tokenHash)
+
+	if err != nil {
+		return "", err
+	}
+
+	return userID, tx.Commit(ctx)
+}
+
+// VerifyEmail consumes a verification token and marks its user's email as
+// verified. It returns pgx.ErrNoRows if the token cannot be used.
+func VerifyEmail(pool *pgxpool.Pool, tokenHash string) (string, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var tx, err = pool.Begin(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer tx.Rollback(ctx)
+
+	userID, err := consumeUserToken(ctx, tx, TokenPurposeVerifyEmail, tokenHash)
+
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users SET email_verified = TRUE, email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`, userID)
+
+	if err != nil {
+		return "", err
+	}
+
+	return userID, tx.Commit(ctx)
+}
+
+// ResetPassword consumes a password reset token and sets the password of
+// its user. Following the mailed link proves the address, so the email is
+// marked verified as well. It returns pgx.ErrNoRows if the token cannot be
+// used.
+func ResetPassword(pool *pgxpool.Pool, tokenHash string, passwordHash string) (string, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var tx, err = pool.Begin(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer tx.Rollback(ctx)
+
+	userID, err := consumeUserToken(ctx, tx, TokenPurposeResetPassword, tokenHash)
+
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users
+		SET password = $2, updated_at = NOW(),
+			email_verified = TRUE, email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = $1
+	`, userID, passwordHash)
+
+	if err != nil {
+		return "", err
+	}
+
+	return userID, tx.Commit(ctx)
+}
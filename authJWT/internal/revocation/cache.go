@@ -0,0 +1,153 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+// CachedStore puts an in-memory cache in front of another store so the
+// auth middleware does not hit the database on every request.
+//
+// A revoked jti is cached until the token expires, since it can never
+// become valid again. "Not revoked" answers and token generations are only
+// cached for ttl: that is how long another replica may keep accepting a
+// token after it was revoked elsewhere. Revocations made through this
+// store take effect locally at once.
+type CachedStore struct {
+	backend Store
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	valid   map[string]time.Time
+	users   map[string]cachedGeneration
+}
+
+type cachedGeneration struct {
+	value     int64
+	expiresAt time.Time
+}
+
+func NewCachedStore(backend Store, ttl time.Duration) *CachedStore {
+	return &CachedStore{
+		backend: backend,
+		ttl:     ttl,
+		revoked: map[string]time.Time{},
+		valid:   map[string]time.Time{},
+		users:   map[string]cachedGeneration{},
+	}
+}
+
+func (s *CachedStore) Revoke(jti string, userID string, expiresAt time.Time) error {
+	if err := s.backend.Revoke(jti, userID, expiresAt); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked[jti] = expiresAt
+	delete(s.valid, jti)
+	return nil
+}
+
+func (s *CachedStore) IsRevoked(jti string) (bool, error) {
+	var now time.Time = time.Now()
+
+	s.mu.Lock()
+	if _, ok := s.revoked[jti]; ok {
+		s.mu.Unlock()
+		return true, nil
+	}
+	if until, ok := s.valid[jti]; ok && now.Before(until) {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.mu.Unlock()
+
+	revoked, err := s.backend.IsRevoked(jti)
+
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if revoked {
+		// the expiry is unknown here; keep it until the next cleanup
+		s.revoked[jti] = now.Add(s.ttl)
+	} else {
+		s.valid[jti] = now.Add(s.ttl)
+	}
+
+	return revoked, nil
+}
+
+func (s *CachedStore) RevokeUser(userID string) error {
+	if err := s.backend.RevokeUser(userID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the next lookup reads the new generation from the backend
+	delete(s.users, userID)
+	return nil
+}
+
+func (s *CachedStore) Generation(userID string) (int64, error) {
+	var now time.Time = time.Now()
+
+	s.mu.Lock()
+	if cached, ok := s.users[userID]; ok && now.Before(cached.expiresAt) {
+		s.mu.Unlock()
+		return cached.value, nil
+	}
+	s.mu.Unlock()
+
+	generation, err := s.backend.Generation(userID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[userID] = cachedGeneration{value: generation, expiresAt: now.Add(s.ttl)}
+	return generation, nil
+}
+
+// DeleteExpired cleans up the backend and drops stale cache entries.
+func (s *CachedStore) DeleteExpired() (int64, error) {
+	deleted, err := s.backend.DeleteExpired()
+
+	if err != nil {
+		return 0, err
+	}
+
+	var now time.Time = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for jti, until := range s.revoked {
+		if until.Before(now) {
+			delete(s.revoked, jti)
+		}
+	}
+	for jti, until := range s.valid {
+		if until.Before(now) {
+			delete(s.valid, jti)
+		}
+	}
+	for userID, cached := range s.users {
+		if cached.expiresAt.Before(now) {
+			delete(s.users, userID)
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,69 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps revocations in process. It suits a single instance or
+// tests; revocations are lost on restart.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+	users  map[string]int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens: map[string]time.Time{},
+		users:  map[string]int64{},
+	}
+}
+
+func (s *MemoryStore) Revoke(jti string, userID string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) IsRevoked(jti string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, revoked := s.tokens[jti]
+	return revoked, nil
+}
+
+func (s *MemoryStore) RevokeUser(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[userID]++
+	return nil
+}
+
+func (s *MemoryStore) Generation(userID string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.users[userID], nil
+}
+
+func (s *MemoryStore) DeleteExpired() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	var now time.Time = time.Now()
+
+	for jti, expiresAt := range s.tokens {
+		if expiresAt.Before(now) {
+			delete(s.tokens, jti)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,38 @@
+package revocation
+
+import (
+	"time"
+	"todo_api/internal/repository"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps revocations in the revoked_tokens table and the
+// users.token_generation column.
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+func (s *PostgresStore) Revoke(jti string, userID string, expiresAt time.Time) error {
+	return repository.InsertRevokedToken(s.pool, jti, userID, expiresAt)
+}
+
+func (s *PostgresStore) IsRevoked(jti string) (bool, error) {
+	return repository.IsTokenRevoked(s.pool, jti)
+}
+
+func (s *PostgresStore) RevokeUser(userID string) error {
+	return repository.IncrementTokenGeneration(s.pool, userID)
+}
+
+func (s *PostgresStore) Generation(userID string) (int64, error) {
+	return repository.GetTokenGeneration(s.pool, userID)
+}
+
+func (s *PostgresStore) DeleteExpired() (int64, error) {
+	return repository.DeleteExpiredRevokedTokens(s.pool)
+}
@@ -0,0 +1,70 @@
+// Package revocation keeps track of access tokens that must no longer be
+// accepted even though they are validly signed and unexpired: single
+// tokens revoked on logout (by jti) and every token of a user issued before
+// a logout-all. Each user has a token generation that logout-all bumps;
+// access tokens carry the generation they were issued under.
+package revocation
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Store is a revocation backend.
+type Store interface {
+	// Revoke rejects the token with jti until it expires anyway.
+	Revoke(jti string, userID string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+	// RevokeUser rejects every token of the user issued so far by moving
+	// them to the next generation.
+	RevokeUser(userID string) error
+	// Generation returns the user's current token generation, 0 until the
+	// first RevokeUser.
+	Generation(userID string) (int64, error)
+	// DeleteExpired forgets revocations of tokens that have expired and
+	// returns how many it removed.
+	DeleteExpired() (int64, error)
+}
+
+// IsTokenRevoked applies both kinds of revocation to a token.
+// generation is the token's gen claim.
+func IsTokenRevoked(store Store, jti string, userID string, generation int64) (bool, error) {
+	revoked, err := store.IsRevoked(jti)
+
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	current, err := store.Generation(userID)
+
+	if err != nil {
+		return false, err
+	}
+
+	return generation < current, nil
+}
+
+// RunCleanup deletes expired revocations every interval until ctx is done.
+func RunCleanup(ctx context.Context, store Store, interval time.Duration) {
+	var ticker *time.Ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := store.DeleteExpired()
+
+			if err != nil {
+				log.Printf("Failed to clean up revoked tokens: %v", err)
+				continue
+			}
+
+			if deleted > 0 {
+				log.Printf("Cleaned up %d expired revoked token(s)", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890",
+// base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC lists 8-digit codes; 6-digit codes are their last six digits.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		code, err := Code(rfcSecret, time.Unix(v.unix, 0))
+
+		if err != nil {
+			t.Fatalf("Code(%d): %v", v.unix, err)
+		}
+
+		if code != v.code {
+			t.Errorf("Code(%d) = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestCodeAcceptsLooseSecret(t *testing.T) {
+	var loose string = strings.ToLower(rfcSecret[:16]) + " " + rfcSecret[16:] + "===="
+
+	code, err := Code(loose, time.Unix(59, 0))
+
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+
+	if code != "287082" {
+		t.Errorf("Code = %s, want 287082", code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var now time.Time = time.Unix(1111111109, 0)
+	var step int64 = Step(now)
+
+	previous, _ := Code(rfcSecret, now.Add(-Period*time.Second))
+	next, _ := Code(rfcSecret, now.Add(Period*time.Second))
+	tooOld, _ := Code(rfcSecret, now.Add(-2*Period*time.Second))
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current", "081804", step, true},
+		{"previous step", previous, step - 1, true},
+		{"next step", next, step + 1, true},
+		{"outside skew", tooOld, 0, false},
+		{"wrong code", "000000", 0, false},
+		{"too short", "81804", 0, false},
+	}
+
+	for _, tt := range tests {
+		gotStep, gotOK := Validate(rfcSecret, tt.code, now, 1)
+
+		if gotOK != tt.wantOK || gotStep != tt.wantStep {
+			t.Errorf("%s: Validate(%q) = %d, %v, want %d, %v", tt.name, tt.code, gotStep, gotOK, tt.wantStep, tt.wantOK)
+		}
+	}
+}
+
+func TestValidateRejectsBadSecret(t *testing.T) {
+	if _, ok := Validate("not base32!", "287082", time.Unix(59, 0), 1); ok {
+		t.Error("Validate accepted a code for an undecodable secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+
+	key, err := decodeSecret(secret)
+
+	if err != nil {
+		t.Fatalf("secret %q does not decode: %v", secret, err)
+	}
+
+	if len(key) != 20 {
+		t.Errorf("secret has %d bytes, want 20", len(key))
+	}
+}
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_todos_title_search;
+DROP INDEX IF EXISTS idx_todos_user_completed;
+DROP INDEX IF EXISTS idx_todos_user_title;
+DROP INDEX IF EXISTS idx_todos_user_updated;
+DROP INDEX IF EXISTS idx_todos_user_created;
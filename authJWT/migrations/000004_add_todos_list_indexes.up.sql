@@ -0,0 +1,10 @@
+-- keyset pagination: one index per sort order, scoped to the owner
+CREATE INDEX IF NOT EXISTS idx_todos_user_created ON todos (user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_todos_user_updated ON todos (user_id, updated_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_todos_user_title ON todos (user_id, title, id);
+
+-- completed/incomplete filter
+CREATE INDEX IF NOT EXISTS idx_todos_user_completed ON todos (user_id, completed);
+
+-- title full-text search
+CREATE INDEX IF NOT EXISTS idx_todos_title_search ON todos USING GIN (to_tsvector('simple', title));
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE name = 'users:unlock';
+
+DROP TABLE IF EXISTS lockout_audit_log;
+DROP TABLE IF EXISTS login_attempts;
@@ -0,0 +1,33 @@
+-- failed logins per key: "account:<email>" or "ip:<address>"
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_last_failure ON login_attempts(last_failure_at);
+
+CREATE TABLE IF NOT EXISTS lockout_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    event VARCHAR(32) NOT NULL,
+    key TEXT NOT NULL,
+    user_id UUID,
+    actor_id UUID,
+    ip TEXT,
+    failures INTEGER,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_lockout_audit_log_created ON lockout_audit_log(created_at);
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:unlock', 'Lift the login lockout of a user')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'users:unlock'
+ON CONFLICT DO NOTHING;
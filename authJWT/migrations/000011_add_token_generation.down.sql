@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_revoked_before TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE users DROP COLUMN IF EXISTS token_generation;
@@ -0,0 +1,7 @@
+-- logout-all bumps token_generation; access tokens carry the generation
+-- they were issued under in the gen claim and older ones are rejected.
+-- This replaces tokens_revoked_before, whose second precision could not
+-- tell a token issued just before a logout-all from one issued just after.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_generation BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE users DROP COLUMN IF EXISTS tokens_revoked_before;
@@ -0,0 +1,8 @@
+// Package migrations embeds the todo API schema migrations, applied with
+// dbmigrate at startup or through "todo_api migrate".
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -4,6 +4,10 @@ Get-Content .env | ForEach-Object {
     }
 }
 
+# the version table is authjwt_schema_migrations, not the CLI default
+$separator = if ($env:DATABASE_URL.Contains('?')) { '&' } else { '?' }
+$env:DATABASE_URL = "$($env:DATABASE_URL)$($separator)x-migrations-table=authjwt_schema_migrations"
+
 $command = $args[0]
 $name = $args[1]
 
@@ -0,0 +1,140 @@
+// Package apperror is the error model of the API: handlers return typed
+// errors and Write turns any error into a consistent JSON response.
+package apperror
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+// Kind classifies an error and decides its HTTP status.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindBadRequest
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindInvalid
+	KindTooLarge
+	KindUnavailable
+)
+
+// FieldError says what is wrong with one input field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a domain error that is safe to show to the client. Err, if set,
+// is the underlying cause and is only logged.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Details []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NotFound reports a missing resource, e.g. NotFound("user").
+func NotFound(resource string) *Error {
+	return &Error{Kind: KindNotFound, Code: "not_found", Message: resource + " not found"}
+}
+
+// Unauthorized reports a missing or invalid credential.
+func Unauthorized(message string) *Error {
+	return &Error{Kind: KindUnauthorized, Code: "unauthorized", Message: message}
+}
+
+// Forbidden reports a caller that is authenticated but not allowed to do
+// what it asked.
+func Forbidden(message string) *Error {
+	return &Error{Kind: KindForbidden, Code: "forbidden", Message: message}
+}
+
+// Conflict reports a request that clashes with existing data.
+func Conflict(message string) *Error {
+	return &Error{Kind: KindConflict, Code: "conflict", Message: message}
+}
+
+// BadRequest reports a request body or parameter that cannot be parsed.
+func BadRequest(message string, err error) *Error {
+	return &Error{Kind: KindBadRequest, Code: "bad_request", Message: message, Err: err}
+}
+
+// Invalid reports input that parsed but failed validation, with one detail
+// per offending field.
+func Invalid(message string, details ...FieldError) *Error {
+	return &Error{Kind: KindInvalid, Code: "validation_failed", Message: message, Details: details}
+}
+
+// TooLarge reports a request body over the size limit.
+func TooLarge(message string) *Error {
+	return &Error{Kind: KindTooLarge, Code: "payload_too_large", Message: message}
+}
+
+// Unavailable reports a dependency (database, broker) that is down.
+func Unavailable(message string, err error) *Error {
+	return &Error{Kind: KindUnavailable, Code: "service_unavailable", Message: message, Err: err}
+}
+
+// Internal wraps an unexpected error. Its message is never shown.
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Code: "internal_error", Message: "internal server error", Err: err}
+}
+
+// From returns err as an *Error, classifying database errors that the
+// handlers did not translate themselves.
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Error{Kind: KindNotFound, Code: "not_found", Message: "resource not found", Err: err}
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code.Name() == "unique_violation":
+			return &Error{Kind: KindConflict, Code: "conflict", Message: "resource already exists", Err: err}
+		case pqErr.Code.Class() == "23": // integrity constraint violation
+			return &Error{Kind: KindInvalid, Code: "validation_failed", Message: "request violates a data constraint", Err: err}
+		case pqErr.Code.Class() == "08", // connection exception
+			pqErr.Code.Class() == "53", // insufficient resources
+			pqErr.Code.Class() == "57": // operator intervention (shutdown)
+			return Unavailable("database unavailable", err)
+		}
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, context.DeadlineExceeded) || isNetError(err) {
+		return Unavailable("database unavailable", err)
+	}
+
+	return Internal(err)
+}
+
+func isNetError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,79 @@
+package apperror
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+type contextKey int
+
+const requestIDKey contextKey = 0
+
+// WithRequestID returns a copy of ctx carrying the request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request id stored in ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// body is the JSON error response.
+type body struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id,omitempty"`
+	Details   []FieldError `json:"details,omitempty"`
+}
+
+// Status maps an error kind to its HTTP status code.
+func Status(kind Kind) int {
+	switch kind {
+	case KindBadRequest:
+		return http.StatusBadRequest
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindInvalid:
+		return http.StatusUnprocessableEntity
+	case KindTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case KindUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// Write sends err to the client as a JSON error body. Internal and
+// unavailable errors are logged with their cause; the client only sees the
+// generic message.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	appErr := From(err)
+	status := Status(appErr.Kind)
+	requestID := RequestID(r.Context())
+
+	if status >= http.StatusInternalServerError {
+		log.Printf("request %s %s %s failed: %v", requestID, r.Method, r.URL.Path, appErr)
+	}
+
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "5")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body{
+		Code:      appErr.Code,
+		Message:   appErr.Message,
+		RequestID: requestID,
+		Details:   appErr.Details,
+	})
+}
@@ -0,0 +1,153 @@
+// Package auth verifies the access tokens issued by the authJWT service and
+// puts the caller on the request context. It works with any net/http
+// router: wrap a handler in Require or Optional.
+//
+// Tokens are checked for signature, expiry and claims only. Revocation
+// (logout) is tracked by authJWT, so a revoked token is accepted here until
+// it expires; keep ACCESS_TOKEN_TTL short.
+package auth
+
+import (
+	"api/apperror"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Principal is the authenticated caller.
+type Principal struct {
+	UserID    string
+	Email     string
+	TokenID   string
+	ExpiresAt time.Time
+	// Claims holds every claim of the token, for checks this package does
+	// not know about.
+	Claims jwt.MapClaims
+}
+
+type contextKey int
+
+const principalKey contextKey = 0
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// FromContext returns the caller of an authenticated request.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok
+}
+
+// Authenticator verifies bearer tokens against a KeySource. Tokens must
+// name issuer in iss and audience in aud, so tokens authJWT (or anyone
+// sharing its keys) mints for other services are refused.
+type Authenticator struct {
+	keys     KeySource
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+func New(keys KeySource, issuer, audience string) *Authenticator {
+	return &Authenticator{keys: keys, issuer: issuer, audience: audience, now: time.Now}
+}
+
+// Verify checks a raw token and returns its principal.
+func (a *Authenticator) Verify(ctx context.Context, raw string) (*Principal, error) {
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return a.keys.Key(ctx, kid, token.Method.Alg())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	// jwt only checks exp when it is present; we require it
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token has no expiry")
+	}
+	if !claims.VerifyIssuer(a.issuer, true) {
+		return nil, errors.New("token has the wrong issuer")
+	}
+	if !claims.VerifyAudience(a.audience, true) {
+		return nil, errors.New("token has the wrong audience")
+	}
+	userID, ok := claims["user_id"].(string)
+	if !ok || userID == "" {
+		return nil, errors.New("token has no user_id")
+	}
+
+	p := &Principal{
+		UserID:    userID,
+		ExpiresAt: time.Unix(int64(exp), 0),
+		Claims:    claims,
+	}
+	p.Email, _ = claims["email"].(string)
+	p.TokenID, _ = claims["jti"].(string)
+
+	return p, nil
+}
+
+// Require rejects requests without a valid bearer token with 401 and
+// passes the others on with their principal in the context.
+func (a *Authenticator) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := bearerToken(r)
+		if err != nil {
+			unauthorized(w, r, err.Error())
+			return
+		}
+
+		p, err := a.Verify(r.Context(), raw)
+		if err != nil {
+			unauthorized(w, r, "invalid or expired token")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+// Optional lets anonymous requests through but still rejects a token that
+// is present and invalid, so a client never silently loses its identity.
+func (a *Authenticator) Optional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		a.Require(next).ServeHTTP(w, r)
+	})
+}
+
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", errors.New("authorization header required")
+	}
+
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", fmt.Errorf("authorization header must be %q", "Bearer <token>")
+	}
+	return strings.TrimSpace(token), nil
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	apperror.Write(w, r, apperror.Unauthorized(message))
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// KeySource returns the verification key for a token's kid and alg header.
+// It must refuse an alg that does not belong to the key.
+type KeySource interface {
+	Key(ctx context.Context, kid, alg string) (interface{}, error)
+}
+
+// ErrUnknownKey means no key matches the token's kid.
+var ErrUnknownKey = errors.New("auth: unknown signing key")
+
+// Secret verifies HS256 tokens signed with a shared secret, as authJWT does
+// when it has no asymmetric keys configured.
+type Secret []byte
+
+func (s Secret) Key(ctx context.Context, kid, alg string) (interface{}, error) {
+	if alg != jwt.SigningMethodHS256.Alg() {
+		return nil, fmt.Errorf("auth: unexpected signing method %s", alg)
+	}
+	return []byte(s), nil
+}
+
+// JWKS verifies tokens with the public keys published by authJWT at
+// /.well-known/jwks.json. The set is cached and refetched every
+// RefreshInterval, or sooner when a token names an unknown kid (a key
+// rotation), but not more often than MinRefreshInterval.
+type JWKS struct {
+	url    string
+	client *http.Client
+
+	RefreshInterval    time.Duration
+	MinRefreshInterval time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]jwk
+	fetchedAt time.Time
+}
+
+type jwk struct {
+	alg string
+	key interface{}
+}
+
+func NewJWKS(url string) *JWKS {
+	return &JWKS{
+		url:                url,
+		client:             &http.Client{Timeout: 5 * time.Second},
+		RefreshInterval:    5 * time.Minute,
+		MinRefreshInterval: 30 * time.Second,
+	}
+}
+
+func (s *JWKS) Key(ctx context.Context, kid, alg string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	age := time.Since(s.fetchedAt)
+	_, known := s.keys[kid]
+
+	if s.keys == nil || age > s.RefreshInterval || (!known && age > s.MinRefreshInterval) {
+		if err := s.fetch(ctx); err != nil && s.keys == nil {
+			return nil, err
+		}
+		// on a failed refresh keep serving the keys we have
+	}
+
+	k, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if k.alg != alg {
+		return nil, fmt.Errorf("auth: kid %q is %s, token says %s", kid, k.alg, alg)
+	}
+	return k.key, nil
+}
+
+// fetch replaces the cached keys. Keys of unsupported types are skipped.
+func (s *JWKS) fetch(ctx context.Context) error {
+	s.fetchedAt = time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("auth: fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: fetching JWKS: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("auth: decoding JWKS: %w", err)
+	}
+
+	keys := map[string]jwk{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch {
+		case k.Kty == "RSA" && k.Alg == "RS256":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = jwk{alg: k.Alg, key: &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}}
+
+		case k.Kty == "OKP" && k.Crv == "Ed25519" && k.Alg == "EdDSA":
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				continue
+			}
+			keys[k.Kid] = jwk{alg: k.Alg, key: ed25519.PublicKey(x)}
+		}
+	}
+
+	s.keys = keys
+	return nil
+}
@@ -1,13 +1,97 @@
 package event
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"events"
+	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+var (
+	// ErrEmitterClosed is returned by Push once Close has been called.
+	ErrEmitterClosed = errors.New("event: emitter is closed")
+	// ErrNacked means the broker refused to take responsibility for the message.
+	ErrNacked = errors.New("event: message was nacked by the broker")
+	// ErrConfirmTimeout means no ack or nack arrived within ConfirmTimeout.
+	ErrConfirmTimeout = errors.New("event: timed out waiting for publisher confirm")
+	// ErrUnroutable means the exchange had no queue bound for the routing key.
+	ErrUnroutable = errors.New("event: message was returned as unroutable")
+)
+
+// PublishError describes why a confirmed publish did not go through. Use
+// errors.Is with the sentinel errors above to tell the cases apart.
+type PublishError struct {
+	Exchange   string
+	RoutingKey string
+	MessageID  string
+	// ReplyCode and ReplyText are set when the broker returned the message.
+	ReplyCode uint16
+	ReplyText string
+	Err       error
+}
+
+func (e *PublishError) Error() string {
+	if e.ReplyText != "" {
+		return fmt.Sprintf("publish %s/%s: %v (%d %s)", e.Exchange, e.RoutingKey, e.Err, e.ReplyCode, e.ReplyText)
+	}
+	return fmt.Sprintf("publish %s/%s: %v", e.Exchange, e.RoutingKey, e.Err)
+}
+
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether publishing the same message again may succeed.
+// Unroutable messages and a closed emitter are permanent failures.
+func (e *PublishError) Temporary() bool {
+	return !errors.Is(e.Err, ErrUnroutable) && !errors.Is(e.Err, ErrEmitterClosed)
+}
+
+// Dialer opens a new connection to RabbitMQ. The emitter calls it once at
+// startup and again every time the broker drops the connection.
+type Dialer func() (*amqp.Connection, error)
+
+// EmitterConfig tunes the publisher. Zero values fall back to sane defaults.
+type EmitterConfig struct {
+	// PoolSize is the number of idle channels kept open for reuse.
+	PoolSize int
+	// ReconnectDelay is the pause between failed reconnect attempts.
+	ReconnectDelay time.Duration
+	// ConfirmTimeout bounds how long Push waits for the broker ack.
+	ConfirmTimeout time.Duration
+	// Mode is the layout used for event envelopes: plain envelope JSON, or
+	// CloudEvents structured or binary mode.
+	Mode events.Mode
+}
+
+// pooledChannel is a confirm-mode channel together with the listener for
+// messages the broker hands back as unroutable.
+type pooledChannel struct {
+	channel *amqp.Channel
+	returns chan amqp.Return
+}
+
+// Emitter is a long-lived publisher for the logs_topic exchange. It owns a
+// single connection and a pool of channels, and is safe for concurrent use.
 type Emitter struct {
+	dial   Dialer
+	config EmitterConfig
+
+	mu         sync.RWMutex
 	connection *amqp.Connection
+	channels   chan *pooledChannel
+	closed     bool
+	done       chan struct{}
+	// inflight counts publishes that hold a channel, so Close can let them
+	// finish
+	inflight sync.WaitGroup
 }
 
 func (e *Emitter) setup() error {
@@ -17,44 +101,331 @@ func (e *Emitter) setup() error {
 	}
 
 	defer channel.Close()
-	return declareExchange(channel)
+
+	return events.DeclareExchanges(channel)
 }
 
+// Push publishes event to logs_topic with severity as the routing key and
+// waits for the broker to confirm it. See PushContext.
 func (e *Emitter) Push(event string, severity string) error {
-	channel, err := e.connection.Channel()
+	return e.PushContext(context.Background(), event, severity)
+}
+
+// PushContext publishes event as a persistent, mandatory message and blocks
+// until the broker acks it, nacks it, returns it as unroutable, or
+// ConfirmTimeout elapses. Failures are reported as *PublishError.
+func (e *Emitter) PushContext(ctx context.Context, event string, severity string) error {
+	msg := e.publishing(event)
+	if msg.MessageId == "" {
+		msg.MessageId = newMessageID()
+	}
+
+	pubErr := &PublishError{
+		Exchange:   events.Exchange,
+		RoutingKey: severity,
+		MessageID:  msg.MessageId,
+	}
+
+	pc, err := e.getChannel()
 	if err != nil {
-		return err
+		pubErr.Err = err
+		return pubErr
 	}
-	defer channel.Close()
+	defer e.inflight.Done()
 
 	log.Println("Pushing to channel")
 
-	err = channel.Publish(
-		"logs_topic",
+	ctx, cancel := context.WithTimeout(ctx, e.config.ConfirmTimeout)
+	defer cancel()
+
+	confirm, err := pc.channel.PublishWithDeferredConfirmWithContext(
+		ctx,
+		events.Exchange,
 		severity,
+		true,
 		false,
-		false,
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(event),
-		},
+		msg,
 	)
 	if err != nil {
-		return err
+		pc.channel.Close()
+		pubErr.Err = err
+		return pubErr
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		// a late confirm or return would confuse the next user of this
+		// channel, so throw it away instead of pooling it
+		pc.channel.Close()
+		pubErr.Err = ErrConfirmTimeout
+		return pubErr
+	}
+	defer e.putChannel(pc)
+
+	// the broker sends basic.return before the ack, so any return for this
+	// message is already buffered by now
+	if ret, ok := pc.returned(pubErr.MessageID); ok {
+		pubErr.ReplyCode = ret.ReplyCode
+		pubErr.ReplyText = ret.ReplyText
+		pubErr.Err = ErrUnroutable
+		return pubErr
+	}
+
+	if !acked {
+		pubErr.Err = ErrNacked
+		return pubErr
 	}
 
 	return nil
 }
 
-func NewEventEmitter(conn *amqp.Connection) (Emitter, error) {
-	emitter := Emitter{
+// publishing lays out an event envelope in the configured mode. Anything
+// that is not an envelope is sent as plain text, as before.
+func (e *Emitter) publishing(event string) amqp.Publishing {
+	if envelope, err := events.Decode([]byte(event)); err == nil && envelope.ID != "" {
+		msg, err := events.ToPublishing(envelope, e.config.Mode)
+		if err == nil {
+			return msg
+		}
+		log.Println("Sending event as plain text:", err)
+	}
+
+	return amqp.Publishing{
+		ContentType:  "text/plain",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Body:         []byte(event),
+	}
+}
+
+// Close stops accepting new publishes, waits for the ones in flight to be
+// confirmed, then closes every pooled channel and the underlying
+// connection. It is safe to call more than once.
+func (e *Emitter) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.done)
+	e.mu.Unlock()
+
+	// in-flight publishes are bounded by ConfirmTimeout
+	e.inflight.Wait()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.drainChannels()
+	return e.connection.Close()
+}
+
+// returned drains buffered returns and reports the one for messageID, if any.
+func (pc *pooledChannel) returned(messageID string) (amqp.Return, bool) {
+	for {
+		select {
+		case ret := <-pc.returns:
+			if ret.MessageId == messageID {
+				return ret, true
+			}
+		default:
+			return amqp.Return{}, false
+		}
+	}
+}
+
+// getChannel hands out an idle pooled channel, or opens a new one when the
+// pool is empty. On success the caller must call e.inflight.Done.
+func (e *Emitter) getChannel() (*pooledChannel, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return nil, ErrEmitterClosed
+	}
+
+	for {
+		select {
+		case pc := <-e.channels:
+			if pc.channel.IsClosed() {
+				continue
+			}
+			e.inflight.Add(1)
+			return pc, nil
+		default:
+			pc, err := e.openChannel()
+			if err != nil {
+				return nil, err
+			}
+			e.inflight.Add(1)
+			return pc, nil
+		}
+	}
+}
+
+// openChannel opens a channel in confirm mode. The caller must hold e.mu.
+func (e *Emitter) openChannel() (*pooledChannel, error) {
+	channel, err := e.connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	err = channel.Confirm(false)
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+
+	return &pooledChannel{
+		channel: channel,
+		returns: channel.NotifyReturn(make(chan amqp.Return, e.config.PoolSize)),
+	}, nil
+}
+
+// putChannel returns a channel to the pool. Closed channels are dropped and
+// channels beyond the pool size are closed.
+func (e *Emitter) putChannel(pc *pooledChannel) {
+	if pc.channel.IsClosed() {
+		return
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		pc.channel.Close()
+		return
+	}
+
+	select {
+	case e.channels <- pc:
+	default:
+		pc.channel.Close()
+	}
+}
+
+// drainChannels closes every idle channel. The caller must hold e.mu.
+func (e *Emitter) drainChannels() {
+	for {
+		select {
+		case pc := <-e.channels:
+			pc.channel.Close()
+		default:
+			return
+		}
+	}
+}
+
+// watch waits for the current connection to drop and reconnects until it
+// succeeds or the emitter is closed.
+func (e *Emitter) watch(notify chan *amqp.Error) {
+	for {
+		select {
+		case <-e.done:
+			return
+		case amqpErr, ok := <-notify:
+			if !ok || amqpErr == nil {
+				// closed on purpose
+				return
+			}
+			log.Println("RabbitMQ connection lost:", amqpErr)
+		}
+
+		notify = e.reconnect()
+		if notify == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials until a new connection is up and returns its close
+// notification channel, or nil if the emitter was closed meanwhile.
+func (e *Emitter) reconnect() chan *amqp.Error {
+	for {
+		select {
+		case <-e.done:
+			return nil
+		default:
+		}
+
+		conn, err := e.dial()
+		if err != nil {
+			log.Println("RabbitMQ reconnect failed:", err)
+			select {
+			case <-e.done:
+				return nil
+			case <-time.After(e.config.ReconnectDelay):
+			}
+			continue
+		}
+
+		notify := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		e.mu.Lock()
+		if e.closed {
+			e.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		e.drainChannels()
+		e.connection = conn
+		err = e.setup()
+		e.mu.Unlock()
+
+		if err != nil {
+			log.Println("RabbitMQ setup after reconnect failed:", err)
+			conn.Close()
+			continue
+		}
+
+		log.Println("RabbitMQ connection re-established")
+		return notify
+	}
+}
+
+// NewEventEmitter dials RabbitMQ, declares the exchange and starts watching
+// the connection so it can be re-established if the broker drops it.
+func NewEventEmitter(dial Dialer, config EmitterConfig) (*Emitter, error) {
+	if config.PoolSize <= 0 {
+		config.PoolSize = 8
+	}
+	if config.ReconnectDelay <= 0 {
+		config.ReconnectDelay = 2 * time.Second
+	}
+	if config.ConfirmTimeout <= 0 {
+		config.ConfirmTimeout = 5 * time.Second
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	notify := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	emitter := &Emitter{
+		dial:       dial,
+		config:     config,
 		connection: conn,
+		channels:   make(chan *pooledChannel, config.PoolSize),
+		done:       make(chan struct{}),
 	}
 
-	err := emitter.setup()
+	err = emitter.setup()
 	if err != nil {
-		return Emitter{}, err
+		conn.Close()
+		return nil, err
 	}
 
+	go emitter.watch(notify)
+
 	return emitter, nil
 }
+
+func newMessageID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
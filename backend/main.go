@@ -1,14 +1,30 @@
 package main
 
 import (
+	"api/apperror"
+	"api/auth"
 	"api/event"
+	"api/migrations"
+	"api/outbox"
+	"api/paginate"
+	"api/repository"
+	"api/validate"
+	"context"
 	"database/sql"
+	"dbmigrate"
 	"encoding/json"
+	"errors"
+	"events"
 	"fmt"
 	"log"
 	"math"
 	"net/http"
 	"os"
+	"os/signal"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -20,25 +36,46 @@ import (
 	_ "api/docs"
 )
 
-type User struct {
-	Id    int    `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
-}
+type User = repository.User
 
-type Config struct {
-	Rabbit *amqp.Connection
-}
+// validateUser checks the fields a client may set on a user.
+func validateUser(u User) error {
+	var v validate.Validator
+
+	v.Required("name", u.Name)
+	v.Length("name", u.Name, 1, 100)
+
+	v.Required("email", u.Email)
+	v.Length("email", u.Email, 3, 254)
+	v.Email("email", u.Email)
 
-type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	return v.Err()
 }
 
-func failOnError(err error, msg string) {
-	if err != nil {
-		log.Panicf("%s: %s", msg, err)
+// decodeUser reads and validates a user from the request body. The id is
+// never taken from the body.
+func decodeUser(w http.ResponseWriter, r *http.Request) (User, error) {
+	var u User
+	if err := validate.DecodeJSON(w, r, &u, 0); err != nil {
+		return User{}, err
 	}
+
+	u.Id = 0
+	u.Name = strings.TrimSpace(u.Name)
+	u.Email = strings.TrimSpace(u.Email)
+
+	return u, validateUser(u)
+}
+
+// eventSource identifies this service in published events.
+const eventSource = "backend-service"
+
+// shutdownTimeout bounds how long in-flight requests get to finish on
+// SIGTERM.
+const shutdownTimeout = 15 * time.Second
+
+type Config struct {
+	Emitter *event.Emitter
 }
 
 // @title Swagger Example API
@@ -56,22 +93,67 @@ func main() {
 	}
 	defer db.Close()
 
-	// create table if not exists
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS users (id SERIAL PRIMARY KEY, name TEXT, email TEXT)")
+	// schema maintenance: backendApp migrate <up|down|status|version|force>
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// bring the schema up to date; replicas take turns on an advisory lock
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		if err := migrateUp(db); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// EVENT_CONTENT_MODE picks the message layout: structured (default),
+	// binary or envelope
+	contentMode := os.Getenv("EVENT_CONTENT_MODE")
+	if contentMode == "" {
+		contentMode = "structured"
+	}
+	mode, err := events.ParseMode(contentMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// connect to rabbitmq once and keep the publisher for the lifetime of the app;
+	// closing it on the way out waits for in-flight publishes to be confirmed
+	emitter, err := event.NewEventEmitter(queueConnect, event.EmitterConfig{Mode: mode})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer emitter.Close()
+
+	app := &Config{
+		Emitter: emitter,
+	}
+
+	// relay outbox rows to rabbitmq in the background
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		outbox.NewRelay(db, app.Emitter).Run(relayCtx)
+	}()
+
+	// user changes and their events are written in one transaction
+	users := repository.NewPostgres(db, enqueueEvent)
+
+	// tokens issued by authJWT; reads are public, changes need a login
+	requireAuth, optionalAuth, err := authMiddlewares()
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	// create router
-	router := mux.NewRouter()
-	router.HandleFunc("/api/go/users", getUsers(db)).Methods("GET")
-	router.HandleFunc("/api/go/users", createUser(db)).Methods("POST")
-	router.HandleFunc("/api/go/users/{id}", getUser(db)).Methods("GET")
-	router.HandleFunc("/api/go/users/{id}", updateUser(db)).Methods("PUT")
-	router.HandleFunc("/api/go/users/{id}", deleteUser(db)).Methods("DELETE")
+	router := newRouter(users, requireAuth, optionalAuth)
 
-	// wrap the router with CORS and JSON content type middlewares
-	enhancedRouter := enableCORS(jsonContentTypeMiddleware(router))
+	// wrap the router with CORS and JSON content type middlewares; every
+	// request gets an id and a panic only fails that one request
+	enhancedRouter := requestIDMiddleware(recoverMiddleware(enableCORS(jsonContentTypeMiddleware(router))))
 
 	// Serve Swagger UI at /swagger/
 	// router.PathPrefix("/swagger/").Handler(httpSwagger.Handler(
@@ -85,7 +167,114 @@ func main() {
 	)).Methods("GET")
 
 	// start server
-	log.Fatal(http.ListenAndServe(":8000", enhancedRouter))
+	server := &http.Server{
+		Addr:    ":8000",
+		Handler: enhancedRouter,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		log.Println("server stopped:", err)
+	case <-ctx.Done():
+		log.Println("shutting down...")
+	}
+
+	// stop accepting requests and let the in-flight ones finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("http shutdown:", err)
+	}
+
+	// stop the relay; rows it did not get to stay in the outbox for next time
+	stopRelay()
+	<-relayDone
+
+	// the deferred emitter.Close and db.Close run next
+	log.Println("shutdown complete")
+}
+
+// newRouter routes the user API to handlers backed by users.
+func newRouter(users repository.UserRepository, requireAuth, optionalAuth func(http.Handler) http.Handler) *mux.Router {
+	router := mux.NewRouter()
+	router.Handle("/api/go/users", optionalAuth(getUsers(users))).Methods("GET")
+	router.Handle("/api/go/users", requireAuth(createUser(users))).Methods("POST")
+	router.Handle("/api/go/users/{id}", optionalAuth(getUser(users))).Methods("GET")
+	router.Handle("/api/go/users/{id}", requireAuth(updateUser(users))).Methods("PUT")
+	router.Handle("/api/go/users/{id}", requireAuth(deleteUser(users))).Methods("DELETE")
+	return router
+}
+
+// authMiddlewares returns the middlewares routes use to declare that they
+// need (requireAuth) or accept (optionalAuth) an authJWT access token.
+// AUTH_JWKS_URL verifies tokens with authJWT's published keys and
+// AUTH_JWT_SECRET with its HS256 secret; AUTH_DISABLED=true turns
+// authentication off for local development. AUTH_ISSUER and AUTH_AUDIENCE
+// must match authJWT's JWT_ISSUER and JWT_AUDIENCE.
+func authMiddlewares() (requireAuth, optionalAuth func(http.Handler) http.Handler, err error) {
+	if os.Getenv("AUTH_DISABLED") == "true" {
+		log.Println("WARNING: authentication is disabled")
+		pass := func(next http.Handler) http.Handler { return next }
+		return pass, pass, nil
+	}
+
+	var keys auth.KeySource
+	switch {
+	case os.Getenv("AUTH_JWKS_URL") != "":
+		keys = auth.NewJWKS(os.Getenv("AUTH_JWKS_URL"))
+	case os.Getenv("AUTH_JWT_SECRET") != "":
+		keys = auth.Secret(os.Getenv("AUTH_JWT_SECRET"))
+	default:
+		return nil, nil, errors.New("set AUTH_JWKS_URL or AUTH_JWT_SECRET (or AUTH_DISABLED=true)")
+	}
+
+	authenticator := auth.New(keys, envOr("AUTH_ISSUER", "authjwt"), envOr("AUTH_AUDIENCE", "go-fullstack-app"))
+	return authenticator.Require, authenticator.Optional, nil
+}
+
+// envOr returns the environment variable key, or fallback if it is unset.
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func newMigrator(db *sql.DB) (*dbmigrate.Migrator, error) {
+	migrator, err := dbmigrate.New(db, migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+	// a table of our own, so authJWT's migrations can share the database
+	migrator.Table = "backend_schema_migrations"
+	migrator.LegacyTable = dbmigrate.DefaultTable
+	migrator.Logf = log.Printf
+	return migrator, nil
+}
+
+func migrateUp(db *sql.DB) error {
+	migrator, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+	_, err = migrator.Up(context.Background())
+	return err
+}
+
+func runMigrate(db *sql.DB, args []string) error {
+	migrator, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+	return dbmigrate.Run(context.Background(), migrator, args, os.Stdout)
 }
 
 func enableCORS(next http.Handler) http.Handler {
@@ -93,7 +282,8 @@ func enableCORS(next http.Handler) http.Handler {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow any origin
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Total-Count, X-Next-Cursor, Link")
 
 		// Check if the request is for CORS preflight
 		if r.Method == "OPTIONS" {
@@ -107,6 +297,42 @@ func enableCORS(next http.Handler) http.Handler {
 
 }
 
+// requestIDMiddleware keeps the caller's X-Request-ID, or makes one up, and
+// echoes it on the response so errors can be traced back to the logs.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = events.NewID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(apperror.WithRequestID(r.Context(), id)))
+	})
+}
+
+// recoverMiddleware turns a panic in a handler into a 500 response instead
+// of letting it reach net/http.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				// net/http uses this panic to abort a response on purpose
+				panic(rec)
+			}
+
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			apperror.Write(w, r, apperror.Internal(fmt.Errorf("panic: %v", rec)))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func jsonContentTypeMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set JSON Content-Type
@@ -149,69 +375,84 @@ func queueConnect() (*amqp.Connection, error) {
 	return connection, nil
 }
 
-func (app *Config) logEventViaRabbit(l LogPayload) {
-	err := app.pushToQueue(l.Name, l.Data)
-	if err != nil {
-		failOnError(err, "Failed to connect to RabbitMQ")
-		return
-	}
-
-}
-
-// // pushToQueue pushes a message into RabbitMQ
-func (app *Config) pushToQueue(name, msg string) error {
-	emitter, err := event.NewEventEmitter(app.Rabbit)
+// enqueueEvent records body in the outbox as part of tx; the outbox relay
+// publishes it to RabbitMQ once tx has committed. The request id becomes
+// the event correlation id.
+func enqueueEvent(ctx context.Context, tx *sql.Tx, body events.Body) error {
+	envelope, err := events.New(eventSource, body)
 	if err != nil {
 		return err
 	}
+	envelope = envelope.WithCorrelationID(apperror.RequestID(ctx))
 
-	payload := LogPayload{
-		Name: name,
-		Data: msg,
-	}
-
-	j, err := json.MarshalIndent(&payload, "", "\t")
+	j, err := events.Encode(envelope)
 	if err != nil {
 		return err
 	}
 
-	err = emitter.Push(string(j), "log.INFO")
-	if err != nil {
-		return err
-	}
-	return nil
+	return outbox.Write(tx, "log.INFO", j)
 }
 
-// getUsers returns all users
+// getUsers returns a page of users
 //
-//	@Summary		Returns all users
-//	@Description	Returns all users
+//	@Summary		Returns a page of users
+//	@Description	Returns users filtered by name/email substring, sorted and paginated by offset or cursor. The total is in X-Total-Count and page links in the Link header.
 //	@Tags			users
 //	@Accept			json
 //	@Produce		json
+//	@Param			name	query		string	false	"Name contains (case-insensitive)"
+//	@Param			email	query		string	false	"Email contains (case-insensitive)"
+//	@Param			sort	query		string	false	"id, name or email; prefix with - for descending"	default(id)
+//	@Param			limit	query		int		false	"Page size (max 500)"	default(50)
+//	@Param			offset	query		int		false	"Rows to skip"
+//	@Param			cursor	query		string	false	"Cursor from X-Next-Cursor or a Link header"
 //	@Success		200		{object}	[]User
+//	@Header			200		{integer}	X-Total-Count	"Number of matching users"
+//	@Header			200		{string}	Link			"first, prev, next and last page links"
+//	@Header			200		{string}	X-Next-Cursor	"Cursor of the next page"
 //	@Router			/users [get]
-func getUsers(db *sql.DB) http.HandlerFunc {
+func getUsers(users repository.UserRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT * FROM users")
+		q := r.URL.Query()
+
+		page, err := paginate.Parse(q, []string{"id", "name", "email"}, "id")
 		if err != nil {
-			log.Fatal(err)
+			apperror.Write(w, r, err)
+			return
 		}
-		defer rows.Close()
 
-		users := []User{} // array of users
-		for rows.Next() {
-			var u User
-			if err := rows.Scan(&u.Id, &u.Name, &u.Email); err != nil {
-				log.Fatal(err)
-			}
-			users = append(users, u)
+		filter := repository.UserFilter{Name: q.Get("name"), Email: q.Get("email")}
+
+		total, err := users.Count(r.Context(), filter)
+		if err != nil {
+			apperror.Write(w, r, err)
+			return
 		}
-		if err := rows.Err(); err != nil {
-			log.Fatal(err)
+
+		// fetch one extra row to know whether there is a next page
+		fetch := page
+		fetch.Limit++
+		list, err := users.List(r.Context(), filter, fetch)
+		if err != nil {
+			apperror.Write(w, r, err)
+			return
 		}
 
-		json.NewEncoder(w).Encode(users)
+		var next *paginate.Cursor
+		if len(list) > page.Limit {
+			list = list[:page.Limit]
+			last := list[len(list)-1]
+			next = &paginate.Cursor{ID: last.Id}
+			switch page.Sort {
+			case "name":
+				next.Value = last.Name
+			case "email":
+				next.Value = last.Email
+			}
+		}
+
+		paginate.SetHeaders(w, r, page, total, next)
+		json.NewEncoder(w).Encode(list)
 	}
 }
 
@@ -225,15 +466,17 @@ func getUsers(db *sql.DB) http.HandlerFunc {
 //		@Param			id	path		int	true	"User ID"
 //		@Success		200	{object}	User
 //	 	@Router 		/users/{id} [get]
-func getUser(db *sql.DB) http.HandlerFunc {
+func getUser(users repository.UserRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		id, err := userID(r)
+		if err != nil {
+			apperror.Write(w, r, err)
+			return
+		}
 
-		var u User
-		err := db.QueryRow("SELECT * FROM users WHERE id = $1", id).Scan(&u.Id, &u.Name, &u.Email)
+		u, err := users.Get(r.Context(), id)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+			writeUserError(w, r, err)
 			return
 		}
 
@@ -242,61 +485,47 @@ func getUser(db *sql.DB) http.HandlerFunc {
 }
 
 // create user
-func createUser(db *sql.DB) http.HandlerFunc {
+func createUser(users repository.UserRepository) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		var u User
-		json.NewDecoder(r.Body).Decode(&u)
-
-		err := db.QueryRow("INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id", u.Name, u.Email).Scan(&u.Id)
+		u, err := decodeUser(w, r)
 		if err != nil {
-			log.Fatal(err)
+			apperror.Write(w, r, err)
+			return
 		}
 
-		// log event via rabbitmq
-		// try to connect to rabbitmq
-		rabbitConn, err := queueConnect()
+		// the user.created event goes out through the outbox
+		created, err := users.Create(r.Context(), u)
 		if err != nil {
-			log.Println(err)
-			os.Exit(1)
-		}
-		defer rabbitConn.Close()
-
-		app := Config{
-			Rabbit: rabbitConn,
-		}
-
-		payload := LogPayload{
-			Name: "userService",
-			Data: `A new user has been created with the name ` + u.Name,
+			writeUserError(w, r, err)
+			return
 		}
-		app.logEventViaRabbit(payload)
 
 		// return the created user
-		json.NewEncoder(w).Encode(u)
+		json.NewEncoder(w).Encode(created)
 	}
 }
 
 // update user
-func updateUser(db *sql.DB) http.HandlerFunc {
+func updateUser(users repository.UserRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var u User
-		json.NewDecoder(r.Body).Decode(&u)
-
-		vars := mux.Vars(r)
-		id := vars["id"]
+		id, err := userID(r)
+		if err != nil {
+			apperror.Write(w, r, err)
+			return
+		}
 
-		// Execute the update query
-		_, err := db.Exec("UPDATE users SET name = $1, email = $2 WHERE id = $3", u.Name, u.Email, id)
+		u, err := decodeUser(w, r)
 		if err != nil {
-			log.Fatal(err)
+			apperror.Write(w, r, err)
+			return
 		}
+		u.Id = id
 
-		// Retrieve the updated user data from the database
-		var updatedUser User
-		err = db.QueryRow("SELECT id, name, email FROM users WHERE id = $1", id).Scan(&updatedUser.Id, &updatedUser.Name, &updatedUser.Email)
+		updatedUser, err := users.Update(r.Context(), u)
 		if err != nil {
-			log.Fatal(err)
+			writeUserError(w, r, err)
+			return
 		}
 
 		// Send the updated user data in the response
@@ -305,25 +534,36 @@ func updateUser(db *sql.DB) http.HandlerFunc {
 }
 
 // delete user
-func deleteUser(db *sql.DB) http.HandlerFunc {
+func deleteUser(users repository.UserRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
-
-		var u User
-		err := db.QueryRow("SELECT * FROM users WHERE id = $1", id).Scan(&u.Id, &u.Name, &u.Email)
+		id, err := userID(r)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+			apperror.Write(w, r, err)
 			return
-		} else {
-			_, err := db.Exec("DELETE FROM users WHERE id = $1", id)
-			if err != nil {
-				//todo : fix error handling
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
+		}
 
-			json.NewEncoder(w).Encode("User deleted")
+		if _, err := users.Delete(r.Context(), id); err != nil {
+			writeUserError(w, r, err)
+			return
 		}
+
+		json.NewEncoder(w).Encode("User deleted")
+	}
+}
+
+// writeUserError maps repository errors to responses.
+func writeUserError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, repository.ErrNotFound) {
+		err = apperror.NotFound("user")
+	}
+	apperror.Write(w, r, err)
+}
+
+// userID parses the {id} path variable.
+func userID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, apperror.Invalid("invalid user id", apperror.FieldError{Field: "id", Message: "must be an integer"})
 	}
+	return id, nil
 }
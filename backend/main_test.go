@@ -0,0 +1,167 @@
+package main
+
+import (
+	"api/repository"
+	"encoding/json"
+	"events"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func pass(next http.Handler) http.Handler { return next }
+
+// newTestServer routes the user API to an in-memory repository.
+func newTestServer() (http.Handler, *repository.Memory) {
+	users := repository.NewMemory()
+	return newRouter(users, pass, pass), users
+}
+
+func do(t *testing.T, h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, dst interface{}) {
+	t.Helper()
+
+	if err := json.NewDecoder(w.Body).Decode(dst); err != nil {
+		t.Fatalf("decoding response %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestUserLifecycle(t *testing.T) {
+	h, users := newTestServer()
+
+	w := do(t, h, "POST", "/api/go/users", `{"name": " Ann ", "email": "ann@example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: status %d, body %s", w.Code, w.Body)
+	}
+	var created User
+	decode(t, w, &created)
+	if created.Id == 0 || created.Name != "Ann" || created.Email != "ann@example.com" {
+		t.Fatalf("create returned %+v", created)
+	}
+
+	w = do(t, h, "GET", "/api/go/users/1", "")
+	var got User
+	decode(t, w, &got)
+	if w.Code != http.StatusOK || got != created {
+		t.Fatalf("get: status %d, user %+v, want %+v", w.Code, got, created)
+	}
+
+	w = do(t, h, "PUT", "/api/go/users/1", `{"name": "Ann Lee", "email": "ann.lee@example.com"}`)
+	var updated User
+	decode(t, w, &updated)
+	if w.Code != http.StatusOK || updated != (User{Id: 1, Name: "Ann Lee", Email: "ann.lee@example.com"}) {
+		t.Fatalf("update: status %d, user %+v", w.Code, updated)
+	}
+
+	w = do(t, h, "GET", "/api/go/users", "")
+	var list []User
+	decode(t, w, &list)
+	if w.Code != http.StatusOK || len(list) != 1 || list[0] != updated || w.Header().Get("X-Total-Count") != "1" {
+		t.Fatalf("list: status %d, users %+v, total %q", w.Code, list, w.Header().Get("X-Total-Count"))
+	}
+
+	w = do(t, h, "DELETE", "/api/go/users/1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: status %d, body %s", w.Code, w.Body)
+	}
+
+	w = do(t, h, "GET", "/api/go/users/1", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("get after delete: status %d, want 404", w.Code)
+	}
+
+	want := []events.Body{
+		events.UserCreated{UserID: 1, Name: "Ann", Email: "ann@example.com"},
+		events.UserUpdated{UserID: 1, Name: "Ann Lee", Email: "ann.lee@example.com"},
+		events.UserDeleted{UserID: 1},
+	}
+	recorded := users.Events()
+	if len(recorded) != len(want) {
+		t.Fatalf("events = %+v, want %+v", recorded, want)
+	}
+	for i := range want {
+		if recorded[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, recorded[i], want[i])
+		}
+	}
+}
+
+func TestMissingUser(t *testing.T) {
+	h, _ := newTestServer()
+
+	tests := []struct {
+		method, body string
+	}{
+		{"GET", ""},
+		{"PUT", `{"name": "Bob", "email": "bob@example.com"}`},
+		{"DELETE", ""},
+	}
+
+	for _, tt := range tests {
+		w := do(t, h, tt.method, "/api/go/users/42", tt.body)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", tt.method, w.Code)
+			continue
+		}
+
+		var body struct{ Code string }
+		decode(t, w, &body)
+		if body.Code != "not_found" {
+			t.Errorf("%s: code %q, want not_found", tt.method, body.Code)
+		}
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	h, users := newTestServer()
+
+	tests := []struct {
+		name, method, target, body string
+		status                     int
+		field                      string
+	}{
+		{"missing name", "POST", "/api/go/users", `{"email": "ann@example.com"}`, http.StatusUnprocessableEntity, "name"},
+		{"bad email", "POST", "/api/go/users", `{"name": "Ann", "email": "ann"}`, http.StatusUnprocessableEntity, "email"},
+		{"unknown field", "POST", "/api/go/users", `{"name": "Ann", "email": "ann@example.com", "admin": true}`, http.StatusUnprocessableEntity, "admin"},
+		{"invalid id", "GET", "/api/go/users/abc", "", http.StatusUnprocessableEntity, "id"},
+		{"malformed body", "POST", "/api/go/users", `{"name":`, http.StatusBadRequest, ""},
+		{"bad limit", "GET", "/api/go/users?limit=0", "", http.StatusUnprocessableEntity, "limit"},
+	}
+
+	for _, tt := range tests {
+		w := do(t, h, tt.method, tt.target, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d (body %s)", tt.name, w.Code, tt.status, w.Body)
+			continue
+		}
+		if tt.field == "" {
+			continue
+		}
+
+		var body struct {
+			Details []struct{ Field string }
+		}
+		decode(t, w, &body)
+		if len(body.Details) == 0 || body.Details[0].Field != tt.field {
+			t.Errorf("%s: details %+v, want field %q", tt.name, body.Details, tt.field)
+		}
+	}
+
+	if recorded := users.Events(); len(recorded) != 0 {
+		t.Errorf("rejected requests recorded events %+v", recorded)
+	}
+}
@@ -0,0 +1,6 @@
+-- IF NOT EXISTS: the table used to be created at startup
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    name TEXT,
+    email TEXT
+);
@@ -0,0 +1,3 @@
+-- sorted listing; id breaks ties so cursors are stable
+CREATE INDEX IF NOT EXISTS idx_users_name_id ON users (name, id);
+CREATE INDEX IF NOT EXISTS idx_users_email_id ON users (email, id);
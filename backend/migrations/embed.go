@@ -0,0 +1,8 @@
+// Package migrations embeds the backend schema migrations, applied with
+// dbmigrate at startup or through "backendApp migrate".
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
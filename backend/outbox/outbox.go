@@ -0,0 +1,255 @@
+// Package outbox implements the transactional outbox pattern: events are
+// written to the outbox table in the same SQL transaction as the change that
+// caused them, and a Relay publishes them to RabbitMQ afterwards. The table
+// is created by the backend migrations.
+package outbox
+
+import (
+	"api/event"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Publisher is the part of event.Emitter the relay needs. Errors with a
+// Temporary method that reports false, such as an unroutable event, are not
+// retried.
+type Publisher interface {
+	PushContext(ctx context.Context, event string, severity string) error
+}
+
+// Write stores an event in the outbox as part of tx. It is only published
+// once tx commits.
+func Write(tx *sql.Tx, routingKey string, payload []byte) error {
+	_, err := tx.Exec("INSERT INTO outbox (routing_key, payload) VALUES ($1, $2)", routingKey, string(payload))
+	return err
+}
+
+// Relay drains pending outbox rows into a Publisher. Each round claims a
+// batch of due rows in one short statement, using SKIP LOCKED so several
+// backend replicas can run a relay at the same time, and then publishes
+// them outside any transaction. A claim pushes the rows' next attempt past
+// ClaimTimeout, so rows of a relay that dies mid-batch are picked up again
+// and published at least once; rows a relay does not get to because it is
+// stopped are handed back straight away.
+//
+// Rows go out in id order, and a batch stops at the first failed row so a
+// broker outage does not reorder them. Beyond that there is no ordering
+// guarantee: a failed row is retried after its backoff, when later rows may
+// already have been published, and rows that fail permanently are parked
+// (dead_at is set) and never published.
+type Relay struct {
+	db        *sql.DB
+	publisher Publisher
+
+	// BatchSize is the maximum number of rows published per round.
+	BatchSize int
+	// ClaimTimeout is how long claimed rows are left to their relay. It
+	// should exceed the time to publish a full batch; otherwise another
+	// relay may publish the same rows again.
+	ClaimTimeout time.Duration
+	// Interval is the pause between rounds when the outbox is drained.
+	Interval time.Duration
+	// MaxBackoff caps the delay before a failed row is retried.
+	MaxBackoff time.Duration
+	// Retention is how long sent rows are kept before they are deleted.
+	Retention time.Duration
+}
+
+// NewRelay returns a relay with default settings.
+func NewRelay(db *sql.DB, publisher Publisher) *Relay {
+	return &Relay{
+		db:           db,
+		publisher:    publisher,
+		BatchSize:    100,
+		ClaimTimeout: 10 * time.Minute,
+		Interval:     time.Second,
+		MaxBackoff:   5 * time.Minute,
+		Retention:    24 * time.Hour,
+	}
+}
+
+// Run publishes pending rows until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	lastCleanup := time.Now()
+	for {
+		sent, err := r.relayBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Println("outbox relay:", err)
+		}
+
+		if time.Since(lastCleanup) > time.Hour {
+			r.cleanup(ctx)
+			lastCleanup = time.Now()
+		}
+
+		// a full batch means there is probably more waiting
+		if sent == r.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type pendingEvent struct {
+	id         int64
+	routingKey string
+	payload    string
+	attempts   int
+}
+
+// relayBatch publishes one batch of due rows and returns how many it
+// finished with.
+func (r *Relay) relayBatch(ctx context.Context) (int, error) {
+	events, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// events[next:] are claimed but untouched; hand them back on the way out
+	next := 0
+	defer func() {
+		if next < len(events) {
+			r.release(events[next:])
+		}
+	}()
+
+	for _, e := range events {
+		if err := ctx.Err(); err != nil {
+			return next, err
+		}
+
+		pushErr := r.publisher.PushContext(ctx, e.payload, e.routingKey)
+		if pushErr != nil && (ctx.Err() != nil || errors.Is(pushErr, event.ErrEmitterClosed)) {
+			// the relay is stopping; the row was not published
+			return next, pushErr
+		}
+		next++
+
+		switch {
+		case pushErr == nil:
+			err = r.exec("UPDATE outbox SET sent_at = now(), attempts = attempts + 1, last_error = NULL WHERE id = $1", e.id)
+
+		case permanent(pushErr):
+			log.Printf("outbox relay: event %d cannot be published, parking it: %v", e.id, pushErr)
+			err = r.exec("UPDATE outbox SET dead_at = now(), attempts = attempts + 1, last_error = $2 WHERE id = $1", e.id, pushErr.Error())
+
+		default:
+			log.Printf("outbox relay: event %d attempt %d failed: %v", e.id, e.attempts+1, pushErr)
+			err = r.exec(
+				"UPDATE outbox SET attempts = attempts + 1, last_error = $2, next_attempt_at = now() + $3 * interval '1 millisecond' WHERE id = $1",
+				e.id, pushErr.Error(), r.backoff(e.attempts+1).Milliseconds())
+			if err == nil {
+				// publishing the rest now would put them ahead of this one
+				return next, nil
+			}
+		}
+		if err != nil {
+			return next, err
+		}
+	}
+
+	return next, nil
+}
+
+// exec runs a bookkeeping statement. It does not use the relay's context,
+// so what was published is still recorded while the relay shuts down.
+func (r *Relay) exec(query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// release makes claimed rows due again so they do not wait out
+// ClaimTimeout.
+func (r *Relay) release(events []pendingEvent) {
+	ids := make([]int64, len(events))
+	for i, e := range events {
+		ids[i] = e.id
+	}
+
+	if err := r.exec("UPDATE outbox SET next_attempt_at = now() WHERE id = ANY($1)", pq.Array(ids)); err != nil {
+		log.Printf("outbox relay: releasing %d events: %v", len(ids), err)
+	}
+}
+
+// permanent reports whether publishing the same event again cannot succeed.
+func permanent(err error) bool {
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && !temporary.Temporary()
+}
+
+// claim takes up to BatchSize due rows for this relay, oldest first, and
+// commits straight away so no lock is held while publishing.
+func (r *Relay) claim(ctx context.Context) ([]pendingEvent, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		UPDATE outbox SET next_attempt_at = now() + $2 * interval '1 millisecond'
+		WHERE id IN (
+			SELECT id
+			FROM outbox
+			WHERE sent_at IS NULL AND dead_at IS NULL AND next_attempt_at <= now()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, routing_key, payload, attempts`, r.BatchSize, r.ClaimTimeout.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []pendingEvent
+	for rows.Next() {
+		var e pendingEvent
+		if err := rows.Scan(&e.id, &e.routingKey, &e.payload, &e.attempts); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep the subquery's order
+	sort.Slice(events, func(i, j int) bool { return events[i].id < events[j].id })
+
+	return events, nil
+}
+
+// backoff doubles the retry delay for every failed attempt, up to MaxBackoff.
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := time.Second
+	for i := 1; i < attempts && delay < r.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.MaxBackoff {
+		delay = r.MaxBackoff
+	}
+	return delay
+}
+
+func (r *Relay) cleanup(ctx context.Context) {
+	_, err := r.db.ExecContext(ctx,
+		"DELETE FROM outbox WHERE sent_at < now() - $1 * interval '1 second'",
+		int64(r.Retention.Seconds()))
+	if err != nil && ctx.Err() == nil {
+		log.Println("outbox cleanup:", err)
+	}
+}
@@ -0,0 +1,180 @@
+// Package paginate parses list query parameters (limit, offset, cursor,
+// sort) and writes the matching X-Total-Count and Link response headers.
+package paginate
+
+import (
+	"api/apperror"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultLimit is the page size when the client does not ask for one.
+	DefaultLimit = 50
+	// MaxLimit caps the page size a client may ask for.
+	MaxLimit = 500
+)
+
+// Params is one page request. Either Offset or Cursor is used, never both.
+type Params struct {
+	Limit  int
+	Offset int
+	Cursor *Cursor
+	Sort   string
+	Desc   bool
+}
+
+// Cursor points just past the last row of the previous page: Value is that
+// row's sort column and ID its id, which breaks ties.
+type Cursor struct {
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+// Encode returns c as an opaque, URL-safe token.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a token made by Cursor.Encode.
+func DecodeCursor(token string) (Cursor, error) {
+	var c Cursor
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// Parse reads limit, offset, cursor and sort from q. sort is a column name
+// from sortable, prefixed with "-" for descending order; it defaults to
+// defaultSort.
+func Parse(q url.Values, sortable []string, defaultSort string) (Params, error) {
+	var errs []apperror.FieldError
+	invalid := func(field, message string) {
+		errs = append(errs, apperror.FieldError{Field: field, Message: message})
+	}
+
+	p := Params{Limit: DefaultLimit, Sort: defaultSort}
+
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxLimit {
+			invalid("limit", "must be between 1 and "+strconv.Itoa(MaxLimit))
+		}
+		p.Limit = n
+	}
+
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			invalid("offset", "must be a non-negative integer")
+		}
+		p.Offset = n
+	}
+
+	if s := q.Get("cursor"); s != "" {
+		c, err := DecodeCursor(s)
+		if err != nil {
+			invalid("cursor", "is not a valid cursor")
+		}
+		p.Cursor = &c
+		if q.Get("offset") != "" {
+			invalid("offset", "cannot be combined with cursor")
+		}
+	}
+
+	if s := q.Get("sort"); s != "" {
+		p.Desc = strings.HasPrefix(s, "-")
+		p.Sort = strings.TrimPrefix(s, "-")
+
+		known := false
+		for _, column := range sortable {
+			known = known || column == p.Sort
+		}
+		if !known {
+			invalid("sort", "must be one of "+strings.Join(sortable, ", ")+", optionally prefixed with -")
+		}
+	}
+
+	if len(errs) > 0 {
+		return Params{}, apperror.Invalid("invalid query parameters", errs...)
+	}
+	return p, nil
+}
+
+// OrderBy returns the ORDER BY clause for p, with id as the tie-breaker.
+// Sort has been checked against the sortable columns by Parse.
+func (p Params) OrderBy() string {
+	direction := " ASC"
+	if p.Desc {
+		direction = " DESC"
+	}
+	if p.Sort == "id" {
+		return "id" + direction
+	}
+	return p.Sort + direction + ", id" + direction
+}
+
+// After returns the comparison operator that selects rows after the cursor.
+func (p Params) After() string {
+	if p.Desc {
+		return "<"
+	}
+	return ">"
+}
+
+// SetHeaders writes X-Total-Count and an RFC 8288 Link header for the page
+// described by p. next is the cursor of the following page, or nil on the
+// last page; it is also sent as X-Next-Cursor so an offset-mode client can
+// switch to cursors.
+func SetHeaders(w http.ResponseWriter, r *http.Request, p Params, total int, next *Cursor) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next != nil {
+		w.Header().Set("X-Next-Cursor", next.Encode())
+	}
+
+	var links []string
+	link := func(rel string, set map[string]string) {
+		q := r.URL.Query()
+		q.Del("offset")
+		q.Del("cursor")
+		for k, v := range set {
+			q.Set(k, v)
+		}
+		q.Set("limit", strconv.Itoa(p.Limit))
+
+		u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+		links = append(links, "<"+u.String()+`>; rel="`+rel+`"`)
+	}
+
+	link("first", nil)
+
+	if p.Cursor != nil {
+		if next != nil {
+			link("next", map[string]string{"cursor": next.Encode()})
+		}
+	} else {
+		if p.Offset > 0 {
+			prev := p.Offset - p.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			link("prev", map[string]string{"offset": strconv.Itoa(prev)})
+		}
+		if p.Offset+p.Limit < total {
+			link("next", map[string]string{"offset": strconv.Itoa(p.Offset + p.Limit)})
+		}
+		if total > 0 {
+			link("last", map[string]string{"offset": strconv.Itoa((total - 1) / p.Limit * p.Limit)})
+		}
+	}
+
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
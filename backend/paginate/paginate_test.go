@@ -0,0 +1,104 @@
+package paginate
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursors := []Cursor{
+		{ID: 7},
+		{Value: "2024-05-01T12:30:00Z", ID: 42},
+		{Value: "name with spaces & symbols/?", ID: 1},
+	}
+
+	for _, c := range cursors {
+		token := c.Encode()
+		if strings.ContainsAny(token, "+/=") {
+			t.Errorf("token %q is not URL-safe", token)
+		}
+
+		got, err := DecodeCursor(token)
+		if err != nil {
+			t.Fatalf("DecodeCursor(%q): %v", token, err)
+		}
+		if got != c {
+			t.Errorf("round trip of %+v gave %+v", c, got)
+		}
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, token := range []string{"not base64!", "bm90IGpzb24"} {
+		if _, err := DecodeCursor(token); err == nil {
+			t.Errorf("DecodeCursor(%q) succeeded", token)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	sortable := []string{"id", "name"}
+	cursor := Cursor{Value: "bob", ID: 3}
+
+	p, err := Parse(url.Values{"limit": {"10"}, "cursor": {cursor.Encode()}, "sort": {"-name"}}, sortable, "id")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if p.Limit != 10 || p.Cursor == nil || *p.Cursor != cursor || p.Sort != "name" || !p.Desc {
+		t.Errorf("Parse = %+v", p)
+	}
+	if p.OrderBy() != "name DESC, id DESC" || p.After() != "<" {
+		t.Errorf("OrderBy() = %q, After() = %q", p.OrderBy(), p.After())
+	}
+
+	p, err = Parse(url.Values{}, sortable, "id")
+	if err != nil {
+		t.Fatalf("Parse defaults: %v", err)
+	}
+	if p.Limit != DefaultLimit || p.Sort != "id" || p.OrderBy() != "id ASC" {
+		t.Errorf("Parse defaults = %+v", p)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	sortable := []string{"id", "name"}
+	tests := []url.Values{
+		{"limit": {"0"}},
+		{"limit": {"501"}},
+		{"offset": {"-1"}},
+		{"cursor": {"garbage!"}},
+		{"cursor": {Cursor{ID: 1}.Encode()}, "offset": {"5"}},
+		{"sort": {"password"}},
+	}
+
+	for _, q := range tests {
+		if _, err := Parse(q, sortable, "id"); err == nil {
+			t.Errorf("Parse(%v) succeeded", q)
+		}
+	}
+}
+
+func TestSetHeadersOffset(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/users?limit=10&offset=10", nil)
+
+	SetHeaders(w, r, Params{Limit: 10, Offset: 10}, 35, nil)
+
+	if got := w.Header().Get("X-Total-Count"); got != "35" {
+		t.Errorf("X-Total-Count = %q, want 35", got)
+	}
+
+	link := w.Header().Get("Link")
+	for _, want := range []string{
+		`</users?limit=10>; rel="first"`,
+		`</users?limit=10&offset=0>; rel="prev"`,
+		`</users?limit=10&offset=20>; rel="next"`,
+		`</users?limit=10&offset=30>; rel="last"`,
+	} {
+		if !strings.Contains(link, want) {
+			t.Errorf("Link %q lacks %s", link, want)
+		}
+	}
+}
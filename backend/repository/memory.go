@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"api/paginate"
+	"context"
+	"events"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Memory is an in-process UserRepository for tests and local runs. It
+// keeps the events it would have published instead of sending them.
+type Memory struct {
+	mu     sync.RWMutex
+	users  map[int]User
+	nextID int
+	events []events.Body
+}
+
+func NewMemory() *Memory {
+	return &Memory{users: map[int]User{}, nextID: 1}
+}
+
+// Events returns the events recorded so far, oldest first.
+func (m *Memory) Events() []events.Body {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]events.Body(nil), m.events...)
+}
+
+func (m *Memory) List(ctx context.Context, filter UserFilter, page paginate.Params) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := []User{}
+	for _, u := range m.users {
+		if filter.matches(u) && (page.Cursor == nil || after(u, page)) {
+			users = append(users, u)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		a, b := sortKey(users[i], page.Sort), sortKey(users[j], page.Sort)
+		if a == b {
+			a, b = sortKey(users[i], "id"), sortKey(users[j], "id")
+		}
+		if page.Desc {
+			return a > b
+		}
+		return a < b
+	})
+
+	if page.Offset >= len(users) {
+		return []User{}, nil
+	}
+	users = users[page.Offset:]
+	if page.Limit > 0 && len(users) > page.Limit {
+		users = users[:page.Limit]
+	}
+
+	return users, nil
+}
+
+func (m *Memory) Count(ctx context.Context, filter UserFilter) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	total := 0
+	for _, u := range m.users {
+		if filter.matches(u) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+func (m *Memory) Get(ctx context.Context, id int) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (m *Memory) Create(ctx context.Context, u User) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u.Id = m.nextID
+	m.nextID++
+	m.users[u.Id] = u
+	m.events = append(m.events, u.createdEvent())
+
+	return u, nil
+}
+
+func (m *Memory) Update(ctx context.Context, u User) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[u.Id]; !ok {
+		return User{}, ErrNotFound
+	}
+	m.users[u.Id] = u
+	m.events = append(m.events, u.updatedEvent())
+
+	return u, nil
+}
+
+func (m *Memory) Delete(ctx context.Context, id int) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	delete(m.users, id)
+	m.events = append(m.events, u.deletedEvent())
+
+	return u, nil
+}
+
+func (filter UserFilter) matches(u User) bool {
+	return containsFold(u.Name, filter.Name) && containsFold(u.Email, filter.Email)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// after reports whether u comes after the page cursor in the page order,
+// matching the row comparison the Postgres repository uses.
+func after(u User, page paginate.Params) bool {
+	value, id := page.Cursor.Value, sortKey(User{Id: page.Cursor.ID}, "id")
+	if page.Sort == "id" {
+		value = id
+	}
+
+	key := sortKey(u, page.Sort)
+	if key == value {
+		key, value = sortKey(u, "id"), id
+	}
+	if page.Desc {
+		return key < value
+	}
+	return key > value
+}
+
+// sortKey returns the value of column for ordering; ids are zero-padded so
+// they order correctly as strings.
+func sortKey(u User, column string) string {
+	switch column {
+	case "name":
+		return u.Name
+	case "email":
+		return u.Email
+	}
+	s := strconv.Itoa(u.Id)
+	return strings.Repeat("0", 20-len(s)) + s
+}
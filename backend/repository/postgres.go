@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"api/paginate"
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// userColumns is the column list every query selects, in Scan order.
+const userColumns = "id, name, email"
+
+// Postgres is the UserRepository backed by the users table.
+type Postgres struct {
+	db      *sql.DB
+	enqueue EnqueueFunc
+}
+
+// NewPostgres returns a repository on db. enqueue is called in the same
+// transaction as every change, so the event is stored only if the change
+// commits.
+func NewPostgres(db *sql.DB, enqueue EnqueueFunc) *Postgres {
+	return &Postgres{db: db, enqueue: enqueue}
+}
+
+func (p *Postgres) List(ctx context.Context, filter UserFilter, page paginate.Params) ([]User, error) {
+	where, args := filterClause(filter)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	// keyset condition for cursor pages, with id breaking ties
+	if page.Cursor != nil {
+		if page.Sort == "id" {
+			where = append(where, "id "+page.After()+" "+arg(page.Cursor.ID))
+		} else {
+			where = append(where, "("+page.Sort+", id) "+page.After()+" ("+arg(page.Cursor.Value)+", "+arg(page.Cursor.ID)+")")
+		}
+	}
+
+	query := "SELECT " + userColumns + " FROM users" + whereClause(where) +
+		" ORDER BY " + page.OrderBy() +
+		" LIMIT " + arg(page.Limit) + " OFFSET " + arg(page.Offset)
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.Id, &u.Name, &u.Email); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+func (p *Postgres) Count(ctx context.Context, filter UserFilter) (int, error) {
+	where, args := filterClause(filter)
+
+	var total int
+	err := p.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+whereClause(where), args...).Scan(&total)
+	return total, err
+}
+
+func (p *Postgres) Get(ctx context.Context, id int) (User, error) {
+	var u User
+	err := p.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id).Scan(&u.Id, &u.Name, &u.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotFound
+	}
+	return u, err
+}
+
+func (p *Postgres) Create(ctx context.Context, u User) (User, error) {
+	var created User
+	err := p.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "INSERT INTO users (name, email) VALUES ($1, $2) RETURNING "+userColumns, u.Name, u.Email).
+			Scan(&created.Id, &created.Name, &created.Email)
+		if err != nil {
+			return err
+		}
+
+		return p.enqueue(ctx, tx, created.createdEvent())
+	})
+	return created, err
+}
+
+func (p *Postgres) Update(ctx context.Context, u User) (User, error) {
+	var updated User
+	err := p.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "UPDATE users SET name = $1, email = $2 WHERE id = $3 RETURNING "+userColumns, u.Name, u.Email, u.Id).
+			Scan(&updated.Id, &updated.Name, &updated.Email)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		return p.enqueue(ctx, tx, updated.updatedEvent())
+	})
+	return updated, err
+}
+
+func (p *Postgres) Delete(ctx context.Context, id int) (User, error) {
+	var deleted User
+	err := p.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "DELETE FROM users WHERE id = $1 RETURNING "+userColumns, id).
+			Scan(&deleted.Id, &deleted.Name, &deleted.Email)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		return p.enqueue(ctx, tx, deleted.deletedEvent())
+	})
+	return deleted, err
+}
+
+// inTx runs fn in a transaction and commits if it returns nil.
+func (p *Postgres) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func filterClause(filter UserFilter) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+
+	if filter.Name != "" {
+		args = append(args, containsPattern(filter.Name))
+		where = append(where, "name ILIKE $"+strconv.Itoa(len(args)))
+	}
+	if filter.Email != "" {
+		args = append(args, containsPattern(filter.Email))
+		where = append(where, "email ILIKE $"+strconv.Itoa(len(args)))
+	}
+
+	return where, args
+}
+
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+// containsPattern returns an ILIKE pattern matching s anywhere, with the
+// LIKE wildcards in s escaped.
+func containsPattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+	return "%" + s + "%"
+}
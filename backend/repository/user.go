@@ -0,0 +1,60 @@
+// Package repository holds the storage of the API resources behind
+// interfaces, so handlers do not depend on the database.
+package repository
+
+import (
+	"api/paginate"
+	"context"
+	"database/sql"
+	"errors"
+	"events"
+)
+
+// ErrNotFound means no user has the requested id.
+var ErrNotFound = errors.New("repository: user not found")
+
+type User struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// UserFilter narrows a user listing. Name and Email match case-insensitive
+// substrings; empty fields are not applied.
+type UserFilter struct {
+	Name  string
+	Email string
+}
+
+// UserRepository stores users. Every change also records the matching
+// user.* event, atomically with the change where the store allows it.
+type UserRepository interface {
+	// List returns at most page.Limit users matching filter, in page.Sort
+	// order with id breaking ties, starting after page.Cursor or skipping
+	// page.Offset rows.
+	List(ctx context.Context, filter UserFilter, page paginate.Params) ([]User, error)
+	// Count returns the number of users matching filter.
+	Count(ctx context.Context, filter UserFilter) (int, error)
+	Get(ctx context.Context, id int) (User, error)
+	// Create stores u under a new id and returns it with the id set.
+	Create(ctx context.Context, u User) (User, error)
+	// Update replaces the name and email of the user with u.Id.
+	Update(ctx context.Context, u User) (User, error)
+	// Delete removes the user and returns what was removed.
+	Delete(ctx context.Context, id int) (User, error)
+}
+
+// EnqueueFunc records an event as part of tx, normally in the outbox.
+type EnqueueFunc func(ctx context.Context, tx *sql.Tx, body events.Body) error
+
+func (u User) createdEvent() events.Body {
+	return events.UserCreated{UserID: u.Id, Name: u.Name, Email: u.Email}
+}
+
+func (u User) updatedEvent() events.Body {
+	return events.UserUpdated{UserID: u.Id, Name: u.Name, Email: u.Email}
+}
+
+func (u User) deletedEvent() events.Body {
+	return events.UserDeleted{UserID: u.Id}
+}
@@ -0,0 +1,64 @@
+package validate
+
+import (
+	"api/apperror"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// MaxBodyBytes is the default request body limit for DecodeJSON.
+const MaxBodyBytes = 1 << 20
+
+// DecodeJSON reads a single JSON object from the request body into dst.
+// Unknown fields, trailing data and bodies over maxBytes are rejected; a
+// maxBytes of zero means MaxBodyBytes.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}, maxBytes int64) error {
+	if maxBytes <= 0 {
+		maxBytes = MaxBodyBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(dst)
+	if err == nil {
+		if decoder.More() || decoder.Decode(&struct{}{}) != io.EOF {
+			return apperror.BadRequest("request body must contain a single JSON object", nil)
+		}
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var sizeErr *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &sizeErr):
+		return apperror.TooLarge(fmt.Sprintf("request body must not be larger than %d bytes", sizeErr.Limit))
+
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return apperror.BadRequest("request body is not valid JSON", err)
+
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			return apperror.BadRequest("request body must be a JSON object", err)
+		}
+		return apperror.Invalid("request validation failed", apperror.FieldError{Field: field, Message: "must be of type " + typeErr.Type.String()})
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for this
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return apperror.Invalid("request validation failed", apperror.FieldError{Field: field, Message: "is not allowed"})
+
+	case errors.Is(err, io.EOF):
+		return apperror.BadRequest("request body must not be empty", err)
+	}
+
+	return apperror.BadRequest("request body could not be read", err)
+}
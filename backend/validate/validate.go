@@ -0,0 +1,85 @@
+// Package validate checks request input and reports every problem at once
+// as field-level errors.
+package validate
+
+import (
+	"api/apperror"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator collects field errors. The zero value is ready to use.
+type Validator struct {
+	errors []apperror.FieldError
+}
+
+// Add records a problem with field.
+func (v *Validator) Add(field, message string) {
+	v.errors = append(v.errors, apperror.FieldError{Field: field, Message: message})
+}
+
+// Check records message for field unless ok.
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.Add(field, message)
+	}
+}
+
+// has reports whether field already has an error, so later rules do not
+// pile on.
+func (v *Validator) has(field string) bool {
+	for _, e := range v.errors {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Required rejects an empty or blank value.
+func (v *Validator) Required(field, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, "is required")
+}
+
+// Length rejects a value shorter than min or longer than max characters.
+// A max of zero means no upper limit.
+func (v *Validator) Length(field, value string, min, max int) {
+	if v.has(field) {
+		return
+	}
+
+	n := utf8.RuneCountInString(value)
+	switch {
+	case n < min:
+		v.Add(field, "must be at least "+strconv.Itoa(min)+" characters")
+	case max > 0 && n > max:
+		v.Add(field, "must be at most "+strconv.Itoa(max)+" characters")
+	}
+}
+
+// Email rejects a value that is not a bare address like name@example.com.
+func (v *Validator) Email(field, value string) {
+	if v.has(field) || value == "" {
+		return
+	}
+
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value || !strings.Contains(value[strings.LastIndex(value, "@")+1:], ".") {
+		v.Add(field, "must be a valid email address")
+	}
+}
+
+// Valid reports whether no errors were recorded.
+func (v *Validator) Valid() bool {
+	return len(v.errors) == 0
+}
+
+// Err returns the collected errors as a validation error, or nil.
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+	return apperror.Invalid("request validation failed", v.errors...)
+}
@@ -0,0 +1,122 @@
+package dbmigrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Usage describes the subcommands handled by Run.
+const Usage = `usage: migrate <command>
+  up [N]          apply all (or the next N) pending migrations
+  down [N|-all]   roll back the last N migrations (default 1) or all of them
+  status          list migrations and whether they are applied
+  version         print the current version
+  force V         set the version to V (-1 for none) and clear the dirty flag`
+
+// Run executes one migrate subcommand, writing its output to w. Services
+// call it from a "migrate" command of their binary.
+func Run(ctx context.Context, m *Migrator, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", Usage)
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := countArg(args, 0)
+		if err != nil {
+			return err
+		}
+		applied, err := m.UpTo(ctx, n)
+		if err != nil {
+			return err
+		}
+		if applied == 0 {
+			fmt.Fprintln(w, "no change")
+		} else {
+			fmt.Fprintf(w, "applied %d migration(s)\n", applied)
+		}
+
+	case "down":
+		n := 1
+		if len(args) > 1 && args[1] == "-all" {
+			n = 0
+		} else {
+			var err error
+			if n, err = countArg(args, 1); err != nil {
+				return err
+			}
+		}
+		rolledBack, err := m.Down(ctx, n)
+		if err != nil {
+			return err
+		}
+		if rolledBack == 0 {
+			fmt.Fprintln(w, "no change")
+		} else {
+			fmt.Fprintf(w, "rolled back %d migration(s)\n", rolledBack)
+		}
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, state)
+		}
+		return tw.Flush()
+
+	case "version":
+		version, dirty, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		if version == NilVersion {
+			fmt.Fprintln(w, "no migration applied")
+		} else if dirty {
+			fmt.Fprintf(w, "%d (dirty)\n", version)
+		} else {
+			fmt.Fprintln(w, version)
+		}
+
+	case "force":
+		if len(args) < 2 {
+			return fmt.Errorf("force needs a version\n%s", Usage)
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		if err := m.Force(ctx, version); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "forced version %d\n", version)
+
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], Usage)
+	}
+
+	return nil
+}
+
+// countArg parses the optional migration count after the command,
+// returning fallback when it is missing.
+func countArg(args []string, fallback int) (int, error) {
+	if len(args) < 2 {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid count %q", args[1])
+	}
+	return n, nil
+}
@@ -0,0 +1,3 @@
+module dbmigrate
+
+go 1.20
@@ -0,0 +1,394 @@
+// Package dbmigrate applies versioned SQL migrations embedded in a service
+// binary. Files are named like golang-migrate's (000001_name.up.sql and
+// 000001_name.down.sql) and the version is kept in a table of the same
+// layout as its schema_migrations, so databases migrated with the migrate
+// CLI can switch to this runner and back. Services sharing a database need
+// a Table each.
+package dbmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// DefaultTable is the version table golang-migrate uses.
+const DefaultTable = "schema_migrations"
+
+// NilVersion is the version of a database with no migration applied; pass
+// it to Force to clear the version.
+const NilVersion = -1
+
+var (
+	// ErrDirty means a migration failed half way outside a transaction (or
+	// under the migrate CLI). Fix the schema by hand, then Force a version.
+	ErrDirty = errors.New("dbmigrate: database is dirty")
+	// ErrUnknownVersion means the database is at a version this binary has
+	// no migration for, e.g. after a rollback of the binary.
+	ErrUnknownVersion = errors.New("dbmigrate: unknown version")
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is one schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load reads the migrations in dir of fsys, ordered by version. Files that
+// do not follow the naming scheme are ignored.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("dbmigrate: %s: %w", entry.Name(), err)
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("dbmigrate: version %d is used by %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("dbmigrate: version %d has no up migration", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Migrator applies migrations to one database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+
+	// Table is the version table. Defaults to DefaultTable. The advisory
+	// lock is derived from it too.
+	Table string
+	// LegacyTable, if set, is where the version was kept before Table. When
+	// Table does not exist yet, it starts at the version found there, so
+	// only name a table no other service has written to.
+	LegacyTable string
+	// Logf, if set, is called for every migration applied or rolled back.
+	Logf func(format string, args ...interface{})
+}
+
+// New returns a Migrator for the migrations in dir of fsys.
+func New(db *sql.DB, fsys fs.FS, dir string) (*Migrator, error) {
+	migrations, err := Load(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations, Table: DefaultTable}, nil
+}
+
+// Status is the state of one migration.
+type Status struct {
+	Version int64
+	Name    string
+	Applied bool
+}
+
+// Up applies all pending migrations and returns how many it applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	return m.UpTo(ctx, 0)
+}
+
+// UpTo applies at most n pending migrations; n <= 0 means all of them.
+func (m *Migrator) UpTo(ctx context.Context, n int) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		current, err := m.cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version <= current {
+				continue
+			}
+			if n > 0 && applied == n {
+				break
+			}
+
+			m.logf("applying %d_%s", migration.Version, migration.Name)
+			if err := m.apply(ctx, conn, migration.Up, migration.Version); err != nil {
+				return fmt.Errorf("dbmigrate: %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the n most recent migrations; n <= 0 rolls back all of
+// them. It returns how many it rolled back.
+func (m *Migrator) Down(ctx context.Context, n int) (int, error) {
+	rolledBack := 0
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		current, err := m.cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version > current {
+				continue
+			}
+			if n > 0 && rolledBack == n {
+				break
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("dbmigrate: %d_%s has no down migration", migration.Version, migration.Name)
+			}
+
+			previous := int64(NilVersion)
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+
+			m.logf("rolling back %d_%s", migration.Version, migration.Name)
+			if err := m.apply(ctx, conn, migration.Down, previous); err != nil {
+				return fmt.Errorf("dbmigrate: %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+			rolledBack++
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Version returns the current version and whether it is dirty. A database
+// with no migration applied is at NilVersion.
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	var version int64
+	var dirty bool
+	err := m.withConn(ctx, func(conn *sql.Conn) error {
+		var err error
+		version, dirty, err = m.version(ctx, conn)
+		return err
+	})
+	return version, dirty, err
+}
+
+// Status lists every known migration and whether it is applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	current, _, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		statuses = append(statuses, Status{
+			Version: migration.Version,
+			Name:    migration.Name,
+			Applied: migration.Version <= current,
+		})
+	}
+	return statuses, nil
+}
+
+// Force sets the version without running any migration and clears the
+// dirty flag. Use NilVersion to mark the database as unmigrated.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	if version != NilVersion && m.find(version) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := m.setVersion(ctx, tx, version); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// apply runs one migration and records version in the same transaction,
+// so a failing migration leaves neither schema changes nor a dirty flag.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, query string, version int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	if err := m.setVersion(ctx, tx, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// cleanVersion returns the current version, refusing to go on from a dirty
+// or unknown one.
+func (m *Migrator) cleanVersion(ctx context.Context, conn *sql.Conn) (int64, error) {
+	version, dirty, err := m.version(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return 0, fmt.Errorf("%w at version %d", ErrDirty, version)
+	}
+	if version != NilVersion && m.find(version) < 0 {
+		return 0, fmt.Errorf("%w: database is at %d", ErrUnknownVersion, version)
+	}
+	return version, nil
+}
+
+func (m *Migrator) version(ctx context.Context, conn *sql.Conn) (int64, bool, error) {
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return 0, false, err
+	}
+
+	var version int64
+	var dirty bool
+	err := conn.QueryRowContext(ctx, "SELECT version, dirty FROM "+m.table()+" LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NilVersion, false, nil
+	}
+	return version, dirty, err
+}
+
+func (m *Migrator) setVersion(ctx context.Context, tx *sql.Tx, version int64) error {
+	if _, err := tx.ExecContext(ctx, "TRUNCATE "+m.table()); err != nil {
+		return err
+	}
+	if version == NilVersion {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO "+m.table()+" (version, dirty) VALUES ($1, false)", version)
+	return err
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	var exists, legacy bool
+	err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL, to_regclass($2) IS NOT NULL",
+		m.table(), sql.NullString{String: m.LegacyTable, Valid: m.LegacyTable != ""}).Scan(&exists, &legacy)
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+m.table()+" (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)"); err != nil {
+		return err
+	}
+	if exists || !legacy {
+		return nil
+	}
+
+	// another process may be adopting the same version right now
+	result, err := conn.ExecContext(ctx, "INSERT INTO "+m.table()+" (version, dirty) SELECT version, dirty FROM "+m.LegacyTable+
+		" WHERE NOT EXISTS (SELECT 1 FROM "+m.table()+") LIMIT 1 ON CONFLICT DO NOTHING")
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		m.logf("dbmigrate: %s starts at the version in %s", m.table(), m.LegacyTable)
+	}
+	return nil
+}
+
+// locked runs fn on one connection while holding a session advisory lock,
+// so replicas starting at the same time migrate one after the other.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	return m.withConn(ctx, func(conn *sql.Conn) error {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", m.lockID()); err != nil {
+			return fmt.Errorf("dbmigrate: acquiring lock: %w", err)
+		}
+		// unlock even if ctx is done; the lock would otherwise live as long
+		// as the pooled connection
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", m.lockID())
+
+		return fn(conn)
+	})
+}
+
+func (m *Migrator) withConn(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return fn(conn)
+}
+
+// lockID derives the advisory lock key from the table name.
+func (m *Migrator) lockID() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("dbmigrate:" + m.table()))
+	return int64(h.Sum64())
+}
+
+func (m *Migrator) table() string {
+	if m.Table == "" {
+		return DefaultTable
+	}
+	return m.Table
+}
+
+func (m *Migrator) find(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Migrator) logf(format string, args ...interface{}) {
+	if m.Logf != nil {
+		m.Logf(format, args...)
+	}
+}
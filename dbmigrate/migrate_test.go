@@ -0,0 +1,58 @@
+package dbmigrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/000010_add_index.up.sql":      {Data: []byte("CREATE INDEX i ON t (a);")},
+		"migrations/000002_create_t.up.sql":       {Data: []byte("CREATE TABLE t (a int);")},
+		"migrations/000002_create_t.down.sql":     {Data: []byte("DROP TABLE t;")},
+		"migrations/000001_init.up.sql":           {Data: []byte("SELECT 1;")},
+		"migrations/README.md":                    {Data: []byte("not a migration")},
+		"migrations/000003_nested.up.sql/ignored": {Data: []byte("")},
+	}
+
+	migrations, err := Load(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	want := []Migration{
+		{Version: 1, Name: "init", Up: "SELECT 1;"},
+		{Version: 2, Name: "create_t", Up: "CREATE TABLE t (a int);", Down: "DROP TABLE t;"},
+		{Version: 10, Name: "add_index", Up: "CREATE INDEX i ON t (a);"},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("Load = %+v, want %+v", migrations, want)
+	}
+	for i := range want {
+		if migrations[i] != want[i] {
+			t.Errorf("migration %d = %+v, want %+v", i, migrations[i], want[i])
+		}
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"conflicting names": {
+			"m/000001_a.up.sql": {Data: []byte("SELECT 1;")},
+			"m/000001_b.up.sql": {Data: []byte("SELECT 2;")},
+		},
+		"down only": {
+			"m/000001_a.down.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+
+	for name, fsys := range tests {
+		if _, err := Load(fsys, "m"); err == nil {
+			t.Errorf("%s: Load succeeded", name)
+		}
+	}
+
+	if _, err := Load(fstest.MapFS{}, "missing"); err == nil {
+		t.Error("Load of a missing dir succeeded")
+	}
+}
@@ -0,0 +1,268 @@
+package events
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// CloudEvents 1.0 AMQP protocol binding.
+// See https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/bindings/amqp-protocol-binding.md
+const (
+	cloudEventsSpecVersion = "1.0"
+	// ContentTypeCloudEvents marks a structured-mode message.
+	ContentTypeCloudEvents = "application/cloudevents+json"
+	// ContentTypeJSON is the content type of envelope and event data bodies.
+	ContentTypeJSON = "application/json"
+
+	// binary-mode attributes are application properties with this prefix;
+	// "cloudEvents_" is accepted too, as the binding allows it for JMS
+	cloudEventsPrefix    = "cloudEvents:"
+	cloudEventsAltPrefix = "cloudEvents_"
+
+	extCorrelationID = "correlationid"
+	extSchemaVersion = "schemaversion"
+)
+
+// Mode selects how an envelope is laid out in an AMQP message.
+type Mode int
+
+const (
+	// ModeEnvelope publishes the envelope JSON as the body.
+	ModeEnvelope Mode = iota
+	// ModeStructured publishes a CloudEvents JSON document as the body.
+	ModeStructured
+	// ModeBinary publishes the event data as the body and the CloudEvents
+	// attributes as cloudEvents:* headers.
+	ModeBinary
+)
+
+// ParseMode maps "envelope", "structured" or "binary" to a Mode.
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "envelope":
+		return ModeEnvelope, nil
+	case "structured":
+		return ModeStructured, nil
+	case "binary":
+		return ModeBinary, nil
+	}
+	return ModeEnvelope, fmt.Errorf("events: unknown content mode %q", s)
+}
+
+// structuredEvent is the CloudEvents JSON event format for an envelope.
+type structuredEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	SchemaVersion   *int            `json:"schemaversion,omitempty"`
+}
+
+// ToPublishing lays e out as an AMQP message in the given mode.
+func ToPublishing(e Envelope, mode Mode) (amqp.Publishing, error) {
+	msg := amqp.Publishing{
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     e.ID,
+		Timestamp:     e.Time,
+		Type:          e.Type,
+		CorrelationId: e.CorrelationID,
+	}
+
+	switch mode {
+	case ModeStructured:
+		schemaVersion := e.SchemaVersion
+		body, err := json.Marshal(structuredEvent{
+			SpecVersion:     cloudEventsSpecVersion,
+			ID:              e.ID,
+			Source:          e.Source,
+			Type:            e.Type,
+			Time:            &e.Time,
+			DataContentType: ContentTypeJSON,
+			Data:            e.Data,
+			CorrelationID:   e.CorrelationID,
+			SchemaVersion:   &schemaVersion,
+		})
+		if err != nil {
+			return amqp.Publishing{}, err
+		}
+		msg.ContentType = ContentTypeCloudEvents + "; charset=utf-8"
+		msg.Body = body
+
+	case ModeBinary:
+		msg.ContentType = ContentTypeJSON
+		msg.Headers = amqp.Table{
+			cloudEventsPrefix + "specversion":    cloudEventsSpecVersion,
+			cloudEventsPrefix + "id":             e.ID,
+			cloudEventsPrefix + "source":         e.Source,
+			cloudEventsPrefix + "type":           e.Type,
+			cloudEventsPrefix + "time":           e.Time.UTC().Format(time.RFC3339Nano),
+			cloudEventsPrefix + extSchemaVersion: int32(e.SchemaVersion),
+		}
+		if e.CorrelationID != "" {
+			msg.Headers[cloudEventsPrefix+extCorrelationID] = e.CorrelationID
+		}
+		msg.Body = e.Data
+
+	default:
+		body, err := Encode(e)
+		if err != nil {
+			return amqp.Publishing{}, err
+		}
+		msg.ContentType = ContentTypeJSON
+		msg.Body = body
+	}
+
+	return msg, nil
+}
+
+// FromDelivery decodes a message in any supported layout: CloudEvents
+// structured mode (by content type), CloudEvents binary mode (by the
+// specversion header), or a plain envelope / legacy payload body.
+func FromDelivery(d amqp.Delivery) (Envelope, error) {
+	if strings.HasPrefix(d.ContentType, ContentTypeCloudEvents) {
+		return decodeStructured(d.Body)
+	}
+
+	if _, ok := cloudEventsHeader(d.Headers, "specversion"); ok {
+		return decodeBinary(d)
+	}
+
+	return Decode(d.Body)
+}
+
+func decodeStructured(body []byte) (Envelope, error) {
+	var ce structuredEvent
+	if err := json.Unmarshal(body, &ce); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if err := checkSpecVersion(ce.SpecVersion); err != nil {
+		return Envelope{}, err
+	}
+
+	e := Envelope{
+		ID:            ce.ID,
+		Type:          ce.Type,
+		Source:        ce.Source,
+		CorrelationID: ce.CorrelationID,
+		Data:          ce.Data,
+	}
+	if ce.Time != nil {
+		e.Time = *ce.Time
+	}
+	if ce.SchemaVersion != nil {
+		e.SchemaVersion = *ce.SchemaVersion
+	}
+	if ce.DataBase64 != "" {
+		data, err := base64.StdEncoding.DecodeString(ce.DataBase64)
+		if err != nil {
+			return Envelope{}, fmt.Errorf("%w: data_base64: %v", ErrMalformed, err)
+		}
+		e.Data = asJSON(data)
+	}
+
+	return e, validate(e)
+}
+
+func decodeBinary(d amqp.Delivery) (Envelope, error) {
+	specVersion, _ := cloudEventsHeader(d.Headers, "specversion")
+	if err := checkSpecVersion(headerString(specVersion)); err != nil {
+		return Envelope{}, err
+	}
+
+	e := Envelope{
+		Data: asJSON(d.Body),
+	}
+	if v, ok := cloudEventsHeader(d.Headers, "id"); ok {
+		e.ID = headerString(v)
+	}
+	if v, ok := cloudEventsHeader(d.Headers, "source"); ok {
+		e.Source = headerString(v)
+	}
+	if v, ok := cloudEventsHeader(d.Headers, "type"); ok {
+		e.Type = headerString(v)
+	}
+	if v, ok := cloudEventsHeader(d.Headers, "time"); ok {
+		switch t := v.(type) {
+		case time.Time:
+			e.Time = t
+		default:
+			parsed, err := time.Parse(time.RFC3339Nano, headerString(v))
+			if err != nil {
+				return Envelope{}, fmt.Errorf("%w: time: %v", ErrMalformed, err)
+			}
+			e.Time = parsed
+		}
+	}
+	if v, ok := cloudEventsHeader(d.Headers, extCorrelationID); ok {
+		e.CorrelationID = headerString(v)
+	}
+	if v, ok := cloudEventsHeader(d.Headers, extSchemaVersion); ok {
+		n, err := strconv.Atoi(headerString(v))
+		if err != nil {
+			return Envelope{}, fmt.Errorf("%w: schemaversion: %v", ErrMalformed, err)
+		}
+		e.SchemaVersion = n
+	}
+
+	return e, validate(e)
+}
+
+func checkSpecVersion(v string) error {
+	if v != cloudEventsSpecVersion {
+		return fmt.Errorf("%w: cloudevents specversion %q", ErrUnsupportedVersion, v)
+	}
+	return nil
+}
+
+// validate applies the envelope rules to an event decoded from CloudEvents.
+func validate(e Envelope) error {
+	if e.ID == "" || e.Type == "" || e.Source == "" {
+		return fmt.Errorf("%w: cloudevent id, source and type are required", ErrMalformed)
+	}
+	if e.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, e.SchemaVersion)
+	}
+	return nil
+}
+
+func cloudEventsHeader(headers amqp.Table, name string) (interface{}, bool) {
+	if v, ok := headers[cloudEventsPrefix+name]; ok {
+		return v, true
+	}
+	v, ok := headers[cloudEventsAltPrefix+name]
+	return v, ok
+}
+
+func headerString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return fmt.Sprint(v)
+}
+
+// asJSON keeps JSON data as is and wraps anything else in a JSON string,
+// since Envelope.Data is always JSON.
+func asJSON(data []byte) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+	if json.Valid(data) {
+		return data
+	}
+	quoted, _ := json.Marshal(string(data))
+	return quoted
+}
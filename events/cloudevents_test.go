@@ -0,0 +1,74 @@
+package events
+
+import (
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestPublishingRoundTrip(t *testing.T) {
+	e, err := New("backend", UserCreated{UserID: 7, Name: "Ann", Email: "ann@example.com"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	e = e.WithCorrelationID("req-1")
+
+	for _, mode := range []Mode{ModeEnvelope, ModeStructured, ModeBinary} {
+		msg, err := ToPublishing(e, mode)
+		if err != nil {
+			t.Fatalf("mode %d: ToPublishing: %v", mode, err)
+		}
+
+		got, err := FromDelivery(amqp.Delivery{
+			ContentType: msg.ContentType,
+			Headers:     msg.Headers,
+			MessageId:   msg.MessageId,
+			Type:        msg.Type,
+			Body:        msg.Body,
+		})
+		if err != nil {
+			t.Fatalf("mode %d: FromDelivery: %v", mode, err)
+		}
+
+		if got.ID != e.ID || got.Type != e.Type || got.Source != e.Source ||
+			got.CorrelationID != e.CorrelationID || got.SchemaVersion != e.SchemaVersion || !got.Time.Equal(e.Time) {
+			t.Errorf("mode %d: got %+v, want %+v", mode, got, e)
+		}
+
+		var body UserCreated
+		if err := got.DecodeData(&body); err != nil {
+			t.Fatalf("mode %d: DecodeData: %v", mode, err)
+		}
+		if body != (UserCreated{UserID: 7, Name: "Ann", Email: "ann@example.com"}) {
+			t.Errorf("mode %d: data = %+v", mode, body)
+		}
+	}
+}
+
+func TestFromDeliveryLegacyPayload(t *testing.T) {
+	got, err := FromDelivery(amqp.Delivery{Body: []byte(`{"name": "log", "data": "hello"}`)})
+	if err != nil {
+		t.Fatalf("FromDelivery: %v", err)
+	}
+	if got.Type != "log" {
+		t.Errorf("Type = %q, want log", got.Type)
+	}
+}
+
+func TestFromDeliveryRejectsMalformed(t *testing.T) {
+	_, err := FromDelivery(amqp.Delivery{Body: []byte("not json")})
+	if err == nil || !IsPermanent(err) {
+		t.Errorf("FromDelivery of garbage: err = %v, want a permanent error", err)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for s, want := range map[string]Mode{"envelope": ModeEnvelope, "Structured": ModeStructured, "binary": ModeBinary} {
+		if got, err := ParseMode(s); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %d, %v", s, got, err)
+		}
+	}
+	if _, err := ParseMode("xml"); err == nil {
+		t.Error("ParseMode(xml) succeeded")
+	}
+}
@@ -0,0 +1,301 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type Consumer struct {
+	conn        *amqp.Connection
+	queueName   string
+	config      ConsumerConfig
+	registry    *Registry
+	retryDelays []time.Duration
+}
+
+// ConsumerConfig controls the queue the consumer reads from and how
+// deliveries are acknowledged.
+type ConsumerConfig struct {
+	// QueueName is the queue to consume. Leave it empty to get an exclusive,
+	// server-named queue that disappears with the connection.
+	QueueName string
+	// Durable keeps the named queue (and its messages) across broker and
+	// consumer restarts.
+	Durable bool
+	// Prefetch is the number of unacked deliveries the broker may push to
+	// us at once. It also bounds how many messages wait for a worker, so a
+	// slow handler stops the broker rather than filling memory. Defaults to
+	// twice Workers.
+	Prefetch int
+	// Workers is the number of messages handled concurrently. Defaults to 1.
+	Workers int
+	// OrderingKey, if set, makes events with the same key be handled one at
+	// a time in arrival order, e.g. KeyByUserID. A retried event loses its
+	// place; see KeyFunc.
+	OrderingKey KeyFunc
+	// RequeueOnError puts a message back on the queue the first time its
+	// handler fails. It only applies when MaxRetries is zero; a message that
+	// fails again after redelivery is parked.
+	RequeueOnError bool
+	// MaxRetries is how many times a failed message is retried through the
+	// delayed retry queues before it is parked. Requires QueueName.
+	MaxRetries int
+	// RetryDelay is the delay before the first retry. It doubles with every
+	// further attempt.
+	RetryDelay time.Duration
+	// Registry routes events to handlers. Defaults to DefaultRegistry.
+	Registry *Registry
+	// ShutdownTimeout is how long Listen waits for running handlers once
+	// its context is cancelled. Defaults to 30 seconds.
+	ShutdownTimeout time.Duration
+}
+
+// ErrDeliveriesClosed means the broker closed the consumer's channel or
+// connection while it was listening.
+var ErrDeliveriesClosed = errors.New("events: delivery channel closed")
+
+func NewConsumer(conn *amqp.Connection, config ConsumerConfig) (Consumer, error) {
+	consumer := Consumer{
+		conn:      conn,
+		queueName: config.QueueName,
+		config:    config,
+		registry:  config.Registry,
+	}
+
+	if consumer.registry == nil {
+		consumer.registry = DefaultRegistry
+	}
+	if consumer.config.Workers <= 0 {
+		consumer.config.Workers = 1
+	}
+	if consumer.config.Prefetch <= 0 {
+		consumer.config.Prefetch = 2 * consumer.config.Workers
+	}
+	if consumer.config.ShutdownTimeout <= 0 {
+		consumer.config.ShutdownTimeout = 30 * time.Second
+	}
+
+	if config.QueueName != "" && config.MaxRetries > 0 {
+		if config.RetryDelay <= 0 {
+			config.RetryDelay = time.Second
+		}
+		consumer.retryDelays = retryDelays(config.RetryDelay, config.MaxRetries)
+	}
+
+	err := consumer.setup()
+	if err != nil {
+		return Consumer{}, err
+	}
+
+	return consumer, nil
+}
+
+func (consumer *Consumer) setup() error {
+	channel, err := consumer.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	err = DeclareExchanges(channel)
+	if err != nil {
+		return err
+	}
+
+	if consumer.queueName == "" {
+		return nil
+	}
+
+	return declareRetryTopology(channel, consumer.queueName, consumer.retryDelays)
+}
+
+// Listen consumes messages for topics until ctx is cancelled, then shuts
+// down gracefully. It returns ErrDeliveriesClosed if the broker closes the
+// channel or connection underneath it.
+func (consumer *Consumer) Listen(ctx context.Context, topics []string) error {
+	ch, err := consumer.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	err = ch.Qos(consumer.config.Prefetch, 0, false)
+	if err != nil {
+		return err
+	}
+
+	// retries are published on this channel and must be confirmed
+	err = ch.Confirm(false)
+	if err != nil {
+		return err
+	}
+
+	var q amqp.Queue
+	if consumer.queueName == "" {
+		q, err = declareRandomQueue(ch)
+	} else {
+		q, err = ensureQueue(consumer.conn, ch, consumer.queueName, consumer.config.Durable)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Println("Exchange Name:" + q.Name)
+
+	for _, s := range topics {
+		err = ch.QueueBind(
+			q.Name,
+			s,
+			Exchange,
+			false,
+			nil,
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	consumerTag := "consumer-" + NewID()
+	messages, err := ch.Consume(q.Name, consumerTag, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	// handlers get their own context so that a shutdown lets them finish;
+	// it is only cancelled once they overrun ShutdownTimeout
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
+
+	pool := newWorkerPool(consumer.config.Workers, consumer.config.Prefetch, consumer.config.OrderingKey, func(t task) {
+		consumer.process(handlerCtx, ch, t.delivery, t.event)
+	})
+
+	fmt.Printf("Waiting for message [Exchange, Queue] [%s, %s]\n", Exchange, q.Name)
+
+	for {
+		select {
+		case <-ctx.Done():
+			consumer.shutdown(ch, consumerTag, messages, pool, cancelHandlers)
+			return nil
+
+		case d, ok := <-messages:
+			if !ok {
+				pool.close()
+				return ErrDeliveriesClosed
+			}
+
+			event, err := FromDelivery(d)
+			if err != nil {
+				// a malformed message will never succeed, so park it right away
+				log.Println("Parking undecodable message:", err)
+				consumer.park(ch, d, err)
+				continue
+			}
+
+			// blocks while the workers are busy; prefetch keeps the broker
+			// from sending more than we can hold meanwhile
+			if !pool.submit(ctx, task{delivery: d, event: event, received: time.Now()}) {
+				d.Nack(false, true)
+				consumer.shutdown(ch, consumerTag, messages, pool, cancelHandlers)
+				return nil
+			}
+		}
+	}
+}
+
+// shutdown stops the broker from sending more messages, hands back the
+// ones already prefetched, and waits for queued and running handlers to
+// finish.
+func (consumer *Consumer) shutdown(ch *amqp.Channel, consumerTag string, messages <-chan amqp.Delivery, pool *workerPool, cancelHandlers context.CancelFunc) {
+	log.Println("Stopping consumer", consumerTag)
+
+	err := ch.Cancel(consumerTag, false)
+	if err != nil {
+		log.Println("Failed to cancel consumer:", err)
+	} else {
+		// the delivery channel is closed once the broker confirms the cancel
+		for d := range messages {
+			d.Nack(false, true)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		pool.close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(consumer.config.ShutdownTimeout):
+		// whatever is still unacked is redelivered once the channel closes
+		log.Println("Handlers did not finish in time, cancelling them")
+		cancelHandlers()
+		<-done
+	}
+}
+
+// process runs the handler for one delivery and acks it only once the
+// handler has succeeded.
+func (consumer *Consumer) process(ctx context.Context, ch *amqp.Channel, d amqp.Delivery, event Envelope) {
+	if ctx.Err() != nil {
+		// shutting down past the deadline; leave it for the next consumer
+		d.Nack(false, true)
+		return
+	}
+
+	err := consumer.registry.Dispatch(ctx, event)
+	if err == nil {
+		if err := d.Ack(false); err != nil {
+			log.Println("Failed to ack message:", err)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		log.Printf("Handling %q interrupted by shutdown, requeueing", event.Type)
+		d.Nack(false, true)
+		return
+	}
+
+	consumer.fail(ch, d, event, err)
+}
+
+// fail retries a delivery whose handler returned cause with backoff, and
+// parks it once the retries are used up.
+func (consumer *Consumer) fail(ch *amqp.Channel, d amqp.Delivery, event Envelope, cause error) {
+	attempt := retryCount(d.Headers) + 1
+
+	if attempt <= len(consumer.retryDelays) && !IsPermanent(cause) {
+		log.Printf("Handling %q failed, retry %d/%d: %v", event.Type, attempt, len(consumer.retryDelays), cause)
+
+		err := consumer.scheduleRetry(ch, d, attempt, cause)
+		if err != nil {
+			log.Println("Failed to schedule retry, requeueing:", err)
+			d.Nack(false, true)
+			return
+		}
+
+		if err := d.Ack(false); err != nil {
+			log.Println("Failed to ack message:", err)
+		}
+		return
+	}
+
+	requeue := len(consumer.retryDelays) == 0 && consumer.config.RequeueOnError && !d.Redelivered && !IsPermanent(cause)
+	if requeue {
+		log.Printf("Handling %q failed, requeueing: %v", event.Type, cause)
+		if err := d.Nack(false, true); err != nil {
+			log.Println("Failed to nack message:", err)
+		}
+		return
+	}
+
+	log.Printf("Handling %q failed, parking: %v", event.Type, cause)
+	consumer.park(ch, d, cause)
+}
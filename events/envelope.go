@@ -0,0 +1,132 @@
+// Package events is the contract between the services that publish to and
+// consume from the logs_topic exchange: the versioned event envelope, the
+// typed event bodies, the exchange topology and the consumer.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SchemaVersion is the envelope version written by Encode. Decode accepts
+// this version and older ones, including the legacy name/data payload
+// (version 0).
+const SchemaVersion = 1
+
+var (
+	// ErrMalformed means a message body is neither an envelope nor a legacy
+	// payload.
+	ErrMalformed = Permanent(errors.New("events: malformed event"))
+	// ErrUnsupportedVersion means the envelope was written by a newer
+	// publisher than this consumer understands.
+	ErrUnsupportedVersion = Permanent(errors.New("events: unsupported schema version"))
+)
+
+// Envelope wraps every event published on logs_topic.
+type Envelope struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	Source        string          `json:"source"`
+	Time          time.Time       `json:"time"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	SchemaVersion int             `json:"schema_version"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// Body is implemented by every typed event body.
+type Body interface {
+	EventType() string
+}
+
+// New wraps body in an envelope from source with a fresh id and timestamp.
+func New(source string, body Body) (Envelope, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		ID:            NewID(),
+		Type:          body.EventType(),
+		Source:        source,
+		Time:          time.Now().UTC(),
+		SchemaVersion: SchemaVersion,
+		Data:          data,
+	}, nil
+}
+
+// WithCorrelationID returns a copy of e that carries id.
+func (e Envelope) WithCorrelationID(id string) Envelope {
+	e.CorrelationID = id
+	return e
+}
+
+// DecodeData unmarshals the envelope body into v, normally a pointer to
+// one of the typed bodies.
+func (e Envelope) DecodeData(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// Encode serializes e after checking the required fields are set.
+func Encode(e Envelope) ([]byte, error) {
+	if e.ID == "" || e.Type == "" || e.Source == "" {
+		return nil, fmt.Errorf("%w: id, type and source are required", ErrMalformed)
+	}
+	if e.SchemaVersion == 0 {
+		e.SchemaVersion = SchemaVersion
+	}
+
+	return json.Marshal(e)
+}
+
+// Decode parses a message body. Legacy {"name","data"} payloads are
+// upgraded to an envelope whose Type is the payload name and whose Data is
+// a Log body.
+func Decode(data []byte) (Envelope, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	if _, ok := raw["type"]; !ok {
+		var legacy Log
+		if err := json.Unmarshal(data, &legacy); err != nil || legacy.Name == "" {
+			return Envelope{}, ErrMalformed
+		}
+
+		body, _ := json.Marshal(legacy)
+		return Envelope{
+			Type: legacy.Name,
+			Data: body,
+		}, nil
+	}
+
+	var e Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	if e.Type == "" {
+		return Envelope{}, fmt.Errorf("%w: missing type", ErrMalformed)
+	}
+	if e.SchemaVersion > SchemaVersion {
+		return Envelope{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, e.SchemaVersion)
+	}
+
+	return e, nil
+}
+
+// NewID returns a random RFC 4122 version 4 UUID.
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
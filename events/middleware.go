@@ -0,0 +1,99 @@
+package events
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// metrics is published on /debug/vars by any HTTP server that serves
+// expvar's handler.
+var metrics = expvar.NewMap("event_handlers")
+
+// Logging logs every event and how long its handler took.
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, event Envelope) error {
+			start := time.Now()
+			err := next.Handle(ctx, event)
+			if err != nil {
+				log.Printf("event %q failed after %s: %v", event.Type, time.Since(start), err)
+			} else {
+				log.Printf("event %q handled in %s", event.Type, time.Since(start))
+			}
+			return err
+		})
+	}
+}
+
+// Recovery turns a panicking handler into a permanent error so one bad
+// message cannot crash the consumer.
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, event Envelope) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("event %q handler panicked: %v\n%s", event.Type, r, debug.Stack())
+					err = Permanent(fmt.Errorf("handler panicked: %v", r))
+				}
+			}()
+			return next.Handle(ctx, event)
+		})
+	}
+}
+
+// otherEventTypes is the metrics label of event types registry has no
+// handler for.
+const otherEventTypes = "other"
+
+// Metrics counts handled and failed events and their total handling time
+// per event type. Types without a handler in registry are counted together
+// as "other", since the type comes from the message and the counters are
+// never removed.
+func Metrics(registry *Registry) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, event Envelope) error {
+			start := time.Now()
+			err := next.Handle(ctx, event)
+
+			label := otherEventTypes
+			if registry.Handles(event.Type) {
+				label = event.Type
+			}
+
+			metrics.Add(label+".handled", 1)
+			metrics.Add(label+".duration_ms", time.Since(start).Milliseconds())
+			if err != nil {
+				metrics.Add(label+".failed", 1)
+			}
+			return err
+		})
+	}
+}
+
+// Timeout cancels the handler context after d and stops waiting for the
+// handler, so a stuck handler fails the message instead of blocking it.
+// The handler runs on its own goroutine, so add Recovery after Timeout.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, event Envelope) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- next.Handle(ctx, event)
+			}()
+
+			select {
+			case err := <-done:
+				return err
+			case <-ctx.Done():
+				return fmt.Errorf("event %q: %w", event.Type, ctx.Err())
+			}
+		})
+	}
+}
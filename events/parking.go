@@ -0,0 +1,101 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ParkedMessage is a message that ran out of retries, or could not be
+// decoded, and now waits in the parking queue.
+type ParkedMessage struct {
+	MessageID  string    `json:"message_id"`
+	RoutingKey string    `json:"routing_key"`
+	Retries    int       `json:"retries"`
+	LastError  string    `json:"last_error"`
+	Timestamp  time.Time `json:"timestamp"`
+	Body       string    `json:"body"`
+}
+
+// ListParked returns up to limit messages from the parking queue of queue
+// without removing them.
+func ListParked(conn *amqp.Connection, queue string, limit int) ([]ParkedMessage, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	// closing the channel hands every unacked message back to the queue
+	defer ch.Close()
+
+	parked := []ParkedMessage{}
+	for len(parked) < limit {
+		d, ok, err := ch.Get(parkingQueueName(queue), false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		lastError, _ := d.Headers[headerLastError].(string)
+		parked = append(parked, ParkedMessage{
+			MessageID:  d.MessageId,
+			RoutingKey: originalRoutingKey(d),
+			Retries:    retryCount(d.Headers),
+			LastError:  lastError,
+			Timestamp:  d.Timestamp,
+			Body:       string(d.Body),
+		})
+	}
+
+	return parked, nil
+}
+
+// ReplayParked moves up to limit messages from the parking queue of queue
+// back onto queue with a fresh retry budget, and returns how many it moved.
+func ReplayParked(conn *amqp.Connection, queue string, limit int) (int, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	err = ch.Confirm(false)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for replayed < limit {
+		d, ok, err := ch.Get(parkingQueueName(queue), false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+
+		headers := amqp.Table{}
+		for k, v := range d.Headers {
+			headers[k] = v
+		}
+		headers[headerOriginalRoutingKey] = originalRoutingKey(d)
+		delete(headers, headerRetryCount)
+		delete(headers, "x-death")
+
+		err = republish(context.Background(), ch, queue, d, headers)
+		if err != nil {
+			d.Nack(false, true)
+			return replayed, err
+		}
+
+		err = d.Ack(false)
+		if err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
@@ -0,0 +1,142 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoHandler is returned by Dispatch when no handler is registered for
+// an event and no fallback is set. It is permanent: the message is parked
+// instead of retried.
+var ErrNoHandler = Permanent(errors.New("event: no handler registered"))
+
+// Handler processes one event.
+type Handler interface {
+	Handle(ctx context.Context, event Envelope) error
+}
+
+// HandlerFunc adapts a plain function to the Handler interface.
+type HandlerFunc func(ctx context.Context, event Envelope) error
+
+func (f HandlerFunc) Handle(ctx context.Context, event Envelope) error {
+	return f(ctx, event)
+}
+
+// Middleware wraps a Handler with cross-cutting behaviour such as logging
+// or timeouts.
+type Middleware func(next Handler) Handler
+
+// Registry routes events to handlers by envelope type.
+type Registry struct {
+	mu         sync.RWMutex
+	handlers   map[string]Handler
+	middleware []Middleware
+	fallback   Handler
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: map[string]Handler{},
+	}
+}
+
+// Register routes events of type name to h, replacing any earlier handler.
+func (r *Registry) Register(name string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers[name] = h
+}
+
+// Use appends middleware to the chain every handler runs through. The
+// first middleware added is the outermost one.
+func (r *Registry) Use(middleware ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Handles reports whether a handler is registered for events of type name.
+func (r *Registry) Handles(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.handlers[name]
+	return ok
+}
+
+// SetFallback sets the handler for events nobody registered for.
+func (r *Registry) SetFallback(h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fallback = h
+}
+
+// Dispatch runs the handler registered for event.Type, or the fallback,
+// through the middleware chain.
+func (r *Registry) Dispatch(ctx context.Context, event Envelope) error {
+	r.mu.RLock()
+	h, ok := r.handlers[event.Type]
+	if !ok {
+		h = r.fallback
+	}
+	middleware := r.middleware
+	r.mu.RUnlock()
+
+	if h == nil {
+		h = HandlerFunc(func(context.Context, Envelope) error {
+			return fmt.Errorf("%w for %q", ErrNoHandler, event.Type)
+		})
+	}
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+
+	return h.Handle(ctx, event)
+}
+
+// DefaultRegistry is the registry consumers use unless told otherwise.
+var DefaultRegistry = NewRegistry()
+
+// Register routes events of type name to h on the DefaultRegistry.
+func Register(name string, h Handler) {
+	DefaultRegistry.Register(name, h)
+}
+
+// Use adds middleware to the DefaultRegistry.
+func Use(middleware ...Middleware) {
+	DefaultRegistry.Use(middleware...)
+}
+
+// SetFallback sets the fallback handler of the DefaultRegistry.
+func SetFallback(h Handler) {
+	DefaultRegistry.SetFallback(h)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as one that retrying will not fix, so the consumer
+// parks the message straight away.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err, or any error it wraps, was marked with
+// Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
@@ -0,0 +1,202 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Failed messages flow through this topology:
+//
+//	logs_topic -> <queue> --handler error--> <queue>.retry.<delay> --ttl--> <queue>
+//	                 |
+//	                 +--retries exhausted / malformed--> <queue>.parking
+//
+// Retry queues have no consumers; RabbitMQ dead-letters a message back to
+// the work queue once its TTL expires. The consumer publishes to the retry
+// and parking queues itself, so the work queue needs no arguments and
+// keeps the ones it was first declared with.
+const (
+	deadLetterExchange = "logs_topic.dlx"
+
+	headerRetryCount         = "x-retry-count"
+	headerLastError          = "x-last-error"
+	headerOriginalRoutingKey = "x-original-routing-key"
+)
+
+// ErrRetryNotAcked is returned when the broker did not confirm a message
+// moved to a retry queue; the original delivery is then requeued instead.
+var ErrRetryNotAcked = errors.New("event: retry publish was not confirmed")
+
+func declareDeadLetterExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
+		deadLetterExchange, // name
+		"direct",           // type
+		true,               // durable?
+		false,              // auto-deleted?
+		false,              // internal?
+		false,              // no-wait?
+		nil,                // arguements?
+	)
+}
+
+func parkingQueueName(queue string) string {
+	return queue + ".parking"
+}
+
+func retryQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", queue, delay.Milliseconds())
+}
+
+// retryDelays returns the backoff for each retry: delay, 2*delay, 4*delay...
+func retryDelays(first time.Duration, retries int) []time.Duration {
+	delays := make([]time.Duration, retries)
+	for i := range delays {
+		delays[i] = first << i
+	}
+	return delays
+}
+
+// declareRetryTopology declares the parking queue and one retry queue per
+// backoff step for queue. The parking queue is also bound to the
+// dead-letter exchange, where work queues declared by earlier versions
+// still send rejected messages.
+func declareRetryTopology(ch *amqp.Channel, queue string, delays []time.Duration) error {
+	parking := parkingQueueName(queue)
+	_, err := ch.QueueDeclare(parking, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	err = ch.QueueBind(parking, queue, deadLetterExchange, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, delay := range delays {
+		_, err = ch.QueueDeclare(retryQueueName(queue, delay), true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retryCount reads how many times a delivery has already been retried.
+func retryCount(headers amqp.Table) int {
+	switch n := headers[headerRetryCount].(type) {
+	case int:
+		return n
+	case int16:
+		return int(n)
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	}
+	return 0
+}
+
+// originalRoutingKey is the routing key the message was first published
+// with, before retries and dead-lettering rewrote it.
+func originalRoutingKey(d amqp.Delivery) string {
+	if key, ok := d.Headers[headerOriginalRoutingKey].(string); ok {
+		return key
+	}
+
+	if deaths, ok := d.Headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if death, ok := deaths[len(deaths)-1].(amqp.Table); ok {
+			if keys, ok := death["routing-keys"].([]interface{}); ok && len(keys) > 0 {
+				if key, ok := keys[0].(string); ok {
+					return key
+				}
+			}
+		}
+	}
+
+	return d.RoutingKey
+}
+
+// republish copies d to queue through the default exchange with headers
+// and waits for the broker to confirm it. ch must be in confirm mode.
+func republish(ctx context.Context, ch *amqp.Channel, queue string, d amqp.Delivery, headers amqp.Table) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, true, false, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		MessageId:       d.MessageId,
+		CorrelationId:   d.CorrelationId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		Body:            d.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrRetryNotAcked
+	}
+
+	return nil
+}
+
+// park moves d to the parking queue with cause in its headers. A
+// server-named queue has no parking queue, so there d is dropped.
+func (consumer *Consumer) park(ch *amqp.Channel, d amqp.Delivery, cause error) {
+	if consumer.queueName == "" {
+		if err := d.Nack(false, false); err != nil {
+			log.Println("Failed to nack message:", err)
+		}
+		return
+	}
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[headerLastError] = cause.Error()
+	headers[headerOriginalRoutingKey] = originalRoutingKey(d)
+
+	err := republish(context.Background(), ch, parkingQueueName(consumer.queueName), d, headers)
+	if err != nil {
+		log.Println("Failed to park message, requeueing:", err)
+		d.Nack(false, true)
+		return
+	}
+
+	if err := d.Ack(false); err != nil {
+		log.Println("Failed to ack message:", err)
+	}
+}
+
+// scheduleRetry moves d to the retry queue for its next attempt.
+func (consumer *Consumer) scheduleRetry(ch *amqp.Channel, d amqp.Delivery, attempt int, cause error) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[headerRetryCount] = int32(attempt)
+	headers[headerLastError] = cause.Error()
+	headers[headerOriginalRoutingKey] = originalRoutingKey(d)
+
+	delay := consumer.retryDelays[attempt-1]
+	return republish(context.Background(), ch, retryQueueName(consumer.queueName, delay), d, headers)
+}
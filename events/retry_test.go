@@ -0,0 +1,79 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRetryDelays(t *testing.T) {
+	got := retryDelays(time.Second, 4)
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+
+	if len(got) != len(want) {
+		t.Fatalf("retryDelays = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("retryDelays[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	if got := retryDelays(time.Second, 0); len(got) != 0 {
+		t.Errorf("retryDelays with no retries = %v, want none", got)
+	}
+}
+
+func TestRetryQueueNames(t *testing.T) {
+	if got := retryQueueName("listener.logs", 1500*time.Millisecond); got != "listener.logs.retry.1500ms" {
+		t.Errorf("retryQueueName = %q", got)
+	}
+	if got := parkingQueueName("listener.logs"); got != "listener.logs.parking" {
+		t.Errorf("parkingQueueName = %q", got)
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	tests := []struct {
+		headers amqp.Table
+		want    int
+	}{
+		{nil, 0},
+		{amqp.Table{headerRetryCount: int32(2)}, 2},
+		{amqp.Table{headerRetryCount: int64(3)}, 3},
+		{amqp.Table{headerRetryCount: "4"}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := retryCount(tt.headers); got != tt.want {
+			t.Errorf("retryCount(%v) = %d, want %d", tt.headers, got, tt.want)
+		}
+	}
+}
+
+func TestOriginalRoutingKey(t *testing.T) {
+	tests := []struct {
+		name string
+		d    amqp.Delivery
+		want string
+	}{
+		{"first delivery", amqp.Delivery{RoutingKey: "log.INFO"}, "log.INFO"},
+		{"retried", amqp.Delivery{
+			RoutingKey: "listener.logs",
+			Headers:    amqp.Table{headerOriginalRoutingKey: "log.ERROR"},
+		}, "log.ERROR"},
+		{"dead-lettered", amqp.Delivery{
+			RoutingKey: "listener.logs",
+			Headers: amqp.Table{"x-death": []interface{}{
+				amqp.Table{"routing-keys": []interface{}{"log.WARNING"}},
+			}},
+		}, "log.WARNING"},
+	}
+
+	for _, tt := range tests {
+		if got := originalRoutingKey(tt.d); got != tt.want {
+			t.Errorf("%s: originalRoutingKey = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"errors"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Exchange is the topic exchange every event is published to.
+const Exchange = "logs_topic"
+
+// DeclareExchanges declares logs_topic and its dead-letter exchange.
+// Publishers and consumers both call it, so either can start first.
+func DeclareExchanges(ch *amqp.Channel) error {
+	err := declareExchange(ch)
+	if err != nil {
+		return err
+	}
+
+	return declareDeadLetterExchange(ch)
+}
+
+func declareExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
+		Exchange, // name
+		"topic",  // type
+		true,     // durable?
+		false,    // auto-deleted?
+		false,    // internal?
+		false,    // no-wait?
+		nil,      // arguements?
+	)
+}
+
+func declareRandomQueue(ch *amqp.Channel) (amqp.Queue, error) {
+	return ch.QueueDeclare(
+		"",    // name?
+		false, // durable?
+		false, // delete when unused?
+		true,  // exclusive?
+		false, // no-wait?
+		nil,   // arguments?
+	)
+}
+
+// declareQueue declares a named queue. Durable queues outlive both the
+// broker and the consumer, so messages published while it is down wait
+// for it.
+func declareQueue(ch *amqp.Channel, name string, durable bool, args amqp.Table) (amqp.Queue, error) {
+	return ch.QueueDeclare(
+		name,    // name?
+		durable, // durable?
+		false,   // delete when unused?
+		false,   // exclusive?
+		false,   // no-wait?
+		args,    // arguments?
+	)
+}
+
+// ensureQueue uses the named queue if it exists, whatever arguments it was
+// declared with, and declares it without arguments otherwise. Redeclaring
+// an existing queue with different arguments fails with
+// PRECONDITION_FAILED, so this keeps queues from older versions working.
+func ensureQueue(conn *amqp.Connection, ch *amqp.Channel, name string, durable bool) (amqp.Queue, error) {
+	// a failed passive declare closes its channel, so probe on a spare one
+	probe, err := conn.Channel()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	q, err := probe.QueueDeclarePassive(name, durable, false, false, false, nil)
+	if err == nil {
+		probe.Close()
+		return q, nil
+	}
+
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) || amqpErr.Code != amqp.NotFound {
+		return amqp.Queue{}, err
+	}
+
+	return declareQueue(ch, name, durable, nil)
+}
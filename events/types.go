@@ -0,0 +1,43 @@
+package events
+
+// Event types carried in Envelope.Type.
+const (
+	TypeUserCreated = "user.created"
+	TypeUserUpdated = "user.updated"
+	TypeUserDeleted = "user.deleted"
+	TypeLog         = "log"
+)
+
+// UserCreated is published by the backend after a user row is inserted.
+type UserCreated struct {
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+}
+
+func (UserCreated) EventType() string { return TypeUserCreated }
+
+// UserUpdated is published by the backend after a user row is changed.
+type UserUpdated struct {
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+}
+
+func (UserUpdated) EventType() string { return TypeUserUpdated }
+
+// UserDeleted is published by the backend after a user row is removed.
+type UserDeleted struct {
+	UserID int `json:"user_id"`
+}
+
+func (UserDeleted) EventType() string { return TypeUserDeleted }
+
+// Log is a free-text event. It is also the body of legacy name/data
+// payloads, in which case the envelope Type is the legacy name.
+type Log struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+func (Log) EventType() string { return TypeLog }
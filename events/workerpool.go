@@ -0,0 +1,171 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// consumerMetrics is published on /debug/vars next to the per-handler
+// counters.
+var consumerMetrics = expvar.NewMap("event_consumer")
+
+// latencyBuckets are the upper bounds of the processing latency histogram.
+var latencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// KeyFunc picks the ordering key of an event. Events with the same key are
+// handled one at a time in arrival order, unless one is retried: a failed
+// event goes through a retry queue and comes back after later events of its
+// key. An empty key means no ordering.
+type KeyFunc func(event Envelope) string
+
+// KeyByUserID orders events by the user_id field of their body, so all
+// events about one user are handled one at a time.
+func KeyByUserID(event Envelope) string {
+	var body struct {
+		UserID json.RawMessage `json:"user_id"`
+	}
+	if err := json.Unmarshal(event.Data, &body); err != nil || len(body.UserID) == 0 {
+		return ""
+	}
+	return string(body.UserID)
+}
+
+type task struct {
+	delivery amqp.Delivery
+	event    Envelope
+	received time.Time
+}
+
+// workerPool runs handlers on a fixed number of goroutines. Unkeyed tasks
+// go to a shared queue any worker can take from; keyed tasks always go to
+// the same worker's own queue, which keeps them in order.
+type workerPool struct {
+	shared chan task
+	keyed  []chan task
+	key    KeyFunc
+	handle func(task)
+	wg     sync.WaitGroup
+}
+
+// newWorkerPool starts workers goroutines. Each queue holds at most buffer
+// tasks; once they are full submit blocks, which stops us reading from the
+// broker until prefetch is exhausted.
+func newWorkerPool(workers, buffer int, key KeyFunc, handle func(task)) *workerPool {
+	p := &workerPool{
+		shared: make(chan task, buffer),
+		key:    key,
+		handle: handle,
+	}
+
+	for i := 0; i < workers; i++ {
+		own := make(chan task, buffer)
+		p.keyed = append(p.keyed, own)
+
+		p.wg.Add(1)
+		go p.work(own)
+	}
+
+	return p
+}
+
+// submit queues t, blocking while the target queue is full. It gives up
+// and returns false if ctx is done first.
+func (p *workerPool) submit(ctx context.Context, t task) bool {
+	queue := p.shared
+	if p.key != nil {
+		if k := p.key(t.event); k != "" {
+			h := fnv.New32a()
+			h.Write([]byte(k))
+			queue = p.keyed[h.Sum32()%uint32(len(p.keyed))]
+		}
+	}
+
+	consumerMetrics.Add("queue_depth", 1)
+
+	select {
+	case queue <- t:
+		return true
+	case <-ctx.Done():
+		consumerMetrics.Add("queue_depth", -1)
+		return false
+	}
+}
+
+// close stops accepting tasks and waits until the queued ones are handled.
+func (p *workerPool) close() {
+	close(p.shared)
+	for _, own := range p.keyed {
+		close(own)
+	}
+	p.wg.Wait()
+}
+
+func (p *workerPool) work(own chan task) {
+	defer p.wg.Done()
+
+	shared := p.shared
+	for own != nil || shared != nil {
+		var t task
+		var ok bool
+
+		// prefer keyed work so an ordered backlog cannot starve
+		select {
+		case t, ok = <-own:
+			if !ok {
+				own = nil
+				continue
+			}
+		default:
+			select {
+			case t, ok = <-own:
+				if !ok {
+					own = nil
+					continue
+				}
+			case t, ok = <-shared:
+				if !ok {
+					shared = nil
+					continue
+				}
+			}
+		}
+
+		p.run(t)
+	}
+}
+
+func (p *workerPool) run(t task) {
+	consumerMetrics.Add("queue_depth", -1)
+	consumerMetrics.Add("busy_workers", 1)
+	consumerMetrics.Add("queue_wait_ms", time.Since(t.received).Milliseconds())
+
+	start := time.Now()
+	p.handle(t)
+	elapsed := time.Since(start)
+
+	consumerMetrics.Add("busy_workers", -1)
+	consumerMetrics.Add("processed", 1)
+	consumerMetrics.Add("processing_ms", elapsed.Milliseconds())
+	consumerMetrics.Add(latencyBucket(elapsed), 1)
+}
+
+func latencyBucket(d time.Duration) string {
+	for _, bound := range latencyBuckets {
+		if d <= bound {
+			return "latency_le_" + strconv.FormatInt(bound.Milliseconds(), 10) + "ms"
+		}
+	}
+	return "latency_gt_" + strconv.FormatInt(latencyBuckets[len(latencyBuckets)-1].Milliseconds(), 10) + "ms"
+}
@@ -0,0 +1,92 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Events with the same key must be handled in submission order even with
+// several workers.
+func TestWorkerPoolKeepsKeyOrder(t *testing.T) {
+	var mu sync.Mutex
+	handled := map[string][]int{}
+
+	pool := newWorkerPool(4, 8, KeyByUserID, func(tk task) {
+		var body struct {
+			UserID int `json:"user_id"`
+			Seq    int `json:"seq"`
+		}
+		json.Unmarshal(tk.event.Data, &body)
+
+		// later events of a key finishing first would reorder them
+		time.Sleep(time.Duration(10-body.Seq%10) * 100 * time.Microsecond)
+
+		mu.Lock()
+		key := strconv.Itoa(body.UserID)
+		handled[key] = append(handled[key], body.Seq)
+		mu.Unlock()
+	})
+
+	for seq := 0; seq < 50; seq++ {
+		for user := 1; user <= 3; user++ {
+			data, _ := json.Marshal(map[string]int{"user_id": user, "seq": seq})
+			if !pool.submit(context.Background(), task{event: Envelope{Data: data}}) {
+				t.Fatal("submit refused with a live context")
+			}
+		}
+	}
+	pool.close()
+
+	for key, seqs := range handled {
+		if len(seqs) != 50 {
+			t.Errorf("user %s: %d events handled, want 50", key, len(seqs))
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Errorf("user %s: handled out of order: %v", key, seqs)
+				break
+			}
+		}
+	}
+}
+
+func TestWorkerPoolSubmitStopsOnCancel(t *testing.T) {
+	release := make(chan struct{})
+	pool := newWorkerPool(1, 1, nil, func(task) { <-release })
+
+	// one task running, one queued: the next submit has to wait
+	pool.submit(context.Background(), task{})
+	pool.submit(context.Background(), task{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if pool.submit(ctx, task{}) {
+		t.Error("submit to a full pool succeeded")
+	}
+
+	close(release)
+	pool.close()
+}
+
+func TestKeyByUserID(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`{"user_id": 42, "name": "x"}`, "42"},
+		{`{"user_id": "abc"}`, `"abc"`},
+		{`{"name": "x"}`, ""},
+		{`not json`, ""},
+	}
+
+	for _, tt := range tests {
+		if got := KeyByUserID(Envelope{Data: json.RawMessage(tt.data)}); got != tt.want {
+			t.Errorf("KeyByUserID(%s) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
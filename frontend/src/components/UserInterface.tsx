@@ -11,6 +11,13 @@ interface User {
   email: string;
 }
 
+// changes need an access token from the auth service login, kept in
+// localStorage under "token"
+const authHeaders = () => {
+  const token = typeof window !== 'undefined' ? localStorage.getItem('token') : null;
+  return token ? { Authorization: `Bearer ${token}` } : {};
+};
+
 interface UserInterfaceProps {
   backendName: string; //go
 }
@@ -44,8 +51,11 @@ const UserInterface: React.FC<UserInterfaceProps> = ({ backendName }) => {
   useEffect(() => {
     const fetchData = async () => {
       try {
-        const response = await axios.get(`${apiUrl}/api/${backendName}/users`);
-        setUsers(response.data.reverse());
+        // newest first; the API returns one page at a time
+        const response = await axios.get(`${apiUrl}/api/${backendName}/users`, {
+          params: { sort: '-id', limit: 100 },
+        });
+        setUsers(response.data);
       } catch (error) {
         console.error('Error fetching data:', error);
       }
@@ -59,7 +69,7 @@ const UserInterface: React.FC<UserInterfaceProps> = ({ backendName }) => {
     e.preventDefault();
 
     try {
-      const response = await axios.post(`${apiUrl}/api/${backendName}/users`, newUser);
+      const response = await axios.post(`${apiUrl}/api/${backendName}/users`, newUser, { headers: authHeaders() });
       setUsers([response.data, ...users]);
       setNewUser({ name: '', email: '' });
     } catch (error) {
@@ -71,7 +81,7 @@ const UserInterface: React.FC<UserInterfaceProps> = ({ backendName }) => {
   const handleUpdateUser = async (e: React.FormEvent<HTMLFormElement>) => {
     e.preventDefault();
     try {
-      await axios.put(`${apiUrl}/api/${backendName}/users/${updateUser.id}`, { name: updateUser.name, email: updateUser.email });
+      await axios.put(`${apiUrl}/api/${backendName}/users/${updateUser.id}`, { name: updateUser.name, email: updateUser.email }, { headers: authHeaders() });
       setUpdateUser({ id: '', name: '', email: '' });
       setUsers(
         users.map((user) => {
@@ -89,7 +99,7 @@ const UserInterface: React.FC<UserInterfaceProps> = ({ backendName }) => {
   // Delete a user
   const deleteUser = async (userId: number) => {
     try {
-      await axios.delete(`${apiUrl}/api/${backendName}/users/${userId}`);
+      await axios.delete(`${apiUrl}/api/${backendName}/users/${userId}`, { headers: authHeaders() });
       setUsers(users.filter((user) => user.id !== userId));
     } catch (error) {
       console.error('Error deleting user:', error);
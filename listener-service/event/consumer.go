@@ -1,149 +0,0 @@
-package event
-
-import (
-	"encoding/json"
-	"fmt"
-	"log"
-
-	amqp "github.com/rabbitmq/amqp091-go"
-)
-
-type Consumer struct {
-	conn      *amqp.Connection
-	queueName string
-}
-
-func NewConsumer(conn *amqp.Connection) (Consumer, error) {
-	consumer := Consumer{
-		conn: conn,
-	}
-
-	err := consumer.setup()
-	if err != nil {
-		return Consumer{}, err
-	}
-
-	return consumer, nil
-}
-
-func (consumer *Consumer) setup() error {
-	channel, err := consumer.conn.Channel()
-	if err != nil {
-		return err
-	}
-
-	return declareExchange(channel)
-}
-
-type Payload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
-}
-
-func (consumer *Consumer) Listen(topics []string) error {
-	ch, err := consumer.conn.Channel()
-	if err != nil {
-		return err
-	}
-	defer ch.Close()
-
-	q, err := declareRandomQueue(ch)
-	if err != nil {
-		return err
-	}
-
-	log.Println("Exchange Name:" + q.Name)
-
-	for _, s := range topics {
-		ch.QueueBind(
-			q.Name,
-			s,
-			"logs_topic",
-			false,
-			nil,
-		)
-
-		if err != nil {
-			return err
-		}
-	}
-
-	messages, err := ch.Consume(q.Name, "", true, false, false, false, nil)
-	if err != nil {
-		return err
-	}
-
-	forever := make(chan bool)
-	go func() {
-		for d := range messages {
-			var payload Payload
-			_ = json.Unmarshal(d.Body, &payload)
-
-			go handlePayload(payload)
-		}
-	}()
-
-	fmt.Printf("Waiting for message [Exchange, Queue] [logs_topic, %s]\n", q.Name)
-	<-forever
-
-	return nil
-}
-
-func handlePayload(payload Payload) {
-	switch payload.Name {
-
-	case "log", "event":
-		// log whatever we get
-		log.Println("Logging event:", payload)
-
-		// err := logEvent(payload)
-		// if err != nil {
-		// 	log.Println(err)
-		// }
-
-	case "auth":
-		// authenticate
-		log.Println("Authenticating event:", payload)
-
-	// you can have as many cases as you want, as long as you write the logic
-	case "userService":
-		log.Println("Hello event received:", payload)
-
-	// you can have as many cases as you want, as long as you write the logic
-
-	default:
-		log.Println("Unknown event type:", payload)
-
-		// err := logEvent(payload)
-		// if err != nil {
-		// 	log.Println(err)
-		// }
-	}
-}
-
-// func logEvent(entry Payload) error {
-// 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
-
-// 	logServiceURL := "http://logger-service/log"
-
-// 	request, err := http.NewRequest("POST", logServiceURL, bytes.NewBuffer(jsonData))
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	request.Header.Set("Content-Type", "application/json")
-
-// 	client := &http.Client{}
-
-// 	response, err := client.Do(request)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	defer response.Body.Close()
-
-// 	if response.StatusCode != http.StatusAccepted {
-// 		return err
-// 	}
-
-// 	return nil
-// }
@@ -2,4 +2,8 @@ module listener
 
 go 1.22.1
 
-require github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+require github.com/rabbitmq/amqp091-go v1.10.0
+
+require events v0.0.0-00010101000000-000000000000
+
+replace events => ../events
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"events"
+	"log"
+	"time"
+)
+
+// registerHandlers wires event types to their handlers. Add a new event
+// type here; the consumer does not need to change.
+func registerHandlers() {
+	events.Use(
+		events.Logging(),
+		events.Metrics(events.DefaultRegistry),
+		events.Timeout(30*time.Second),
+		events.Recovery(),
+	)
+
+	// legacy name/data payloads
+	events.Register("log", events.HandlerFunc(logHandler))
+	events.Register("event", events.HandlerFunc(logHandler))
+	events.Register("auth", events.HandlerFunc(authHandler))
+	events.Register("userService", events.HandlerFunc(logHandler))
+
+	events.Register(events.TypeLog, events.HandlerFunc(logHandler))
+	events.Register(events.TypeUserCreated, events.HandlerFunc(userCreatedHandler))
+	events.Register(events.TypeUserUpdated, events.HandlerFunc(userUpdatedHandler))
+	events.Register(events.TypeUserDeleted, events.HandlerFunc(userDeletedHandler))
+
+	events.SetFallback(events.HandlerFunc(unknownHandler))
+}
+
+func logHandler(ctx context.Context, event events.Envelope) error {
+	var entry events.Log
+	if err := event.DecodeData(&entry); err != nil {
+		return events.Permanent(err)
+	}
+
+	// log whatever we get
+	log.Printf("Logging event %s: %s", entry.Name, entry.Data)
+	return nil
+}
+
+func authHandler(ctx context.Context, event events.Envelope) error {
+	// authenticate
+	log.Println("Authenticating event:", string(event.Data))
+	return nil
+}
+
+func userCreatedHandler(ctx context.Context, event events.Envelope) error {
+	var user events.UserCreated
+	if err := event.DecodeData(&user); err != nil {
+		return events.Permanent(err)
+	}
+
+	log.Printf("User %d created: %s <%s> (correlation id %q)", user.UserID, user.Name, user.Email, event.CorrelationID)
+	return nil
+}
+
+func userUpdatedHandler(ctx context.Context, event events.Envelope) error {
+	var user events.UserUpdated
+	if err := event.DecodeData(&user); err != nil {
+		return events.Permanent(err)
+	}
+
+	log.Printf("User %d updated: %s <%s> (correlation id %q)", user.UserID, user.Name, user.Email, event.CorrelationID)
+	return nil
+}
+
+func userDeletedHandler(ctx context.Context, event events.Envelope) error {
+	var user events.UserDeleted
+	if err := event.DecodeData(&user); err != nil {
+		return events.Permanent(err)
+	}
+
+	log.Printf("User %d deleted (correlation id %q)", user.UserID, event.CorrelationID)
+	return nil
+}
+
+func unknownHandler(ctx context.Context, event events.Envelope) error {
+	log.Printf("Unknown event type %q from %q: %s", event.Type, event.Source, string(event.Data))
+	return nil
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"events"
 	"fmt"
-	"listener/event"
 	"log"
 	"math"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -18,6 +22,12 @@ func failOnError(err error, msg string) {
 }
 
 func main() {
+	// maintenance commands for parked messages
+	if len(os.Args) > 1 && os.Args[1] == "parked" {
+		runParked(os.Args[2:])
+		return
+	}
+
 	// try to connect to rabbitmq
 	rabbitConn, err := connect()
 	if err != nil {
@@ -29,17 +39,52 @@ func main() {
 	// start listening for messages
 	log.Println("Listening for and consuming RabbitMQ messages...")
 
+	// route events to their handlers
+	registerHandlers()
+
 	// create consumer
-	consumer, err := event.NewConsumer(rabbitConn)
+	orderingKey, err := orderingKey(envString("LISTENER_ORDER_BY", ""))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	consumer, err := events.NewConsumer(rabbitConn, events.ConsumerConfig{
+		QueueName:       envString("LISTENER_QUEUE", "listener.logs"),
+		Durable:         envBool("LISTENER_QUEUE_DURABLE", true),
+		Prefetch:        envInt("LISTENER_PREFETCH", 10),
+		Workers:         envInt("LISTENER_WORKERS", 4),
+		OrderingKey:     orderingKey,
+		RequeueOnError:  envBool("LISTENER_REQUEUE_ON_ERROR", true),
+		MaxRetries:      envInt("LISTENER_MAX_RETRIES", 5),
+		RetryDelay:      envDuration("LISTENER_RETRY_DELAY", time.Second),
+		ShutdownTimeout: envDuration("LISTENER_SHUTDOWN_TIMEOUT", 30*time.Second),
+	})
 	if err != nil {
 		panic(err)
 	}
 
+	// stop consuming on SIGINT/SIGTERM, finish in-flight messages, then
+	// close the connection
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// queue depth and latency on /debug/vars
+	if addr := envString("LISTENER_METRICS_ADDR", ""); addr != "" {
+		go serveMetrics(ctx, addr)
+	}
+
 	// watch the queue and consume events
-	err = consumer.Listen([]string{"log.INFO", "log.WARNING", "log.ERROR", "hello"})
+	err = consumer.Listen(ctx, []string{"log.INFO", "log.WARNING", "log.ERROR", "hello"})
 	if err != nil {
+		// e.g. the broker went away; exit non-zero so the container is
+		// restarted and connects again
 		log.Println(err)
+		stop()
+		rabbitConn.Close()
+		os.Exit(1)
 	}
+
+	log.Println("Listener stopped")
 }
 
 func connect() (*amqp.Connection, error) {
@@ -77,3 +122,45 @@ func connect() (*amqp.Connection, error) {
 
 	return connection, nil
 }
+
+// orderingKey maps LISTENER_ORDER_BY to the key events are serialized on.
+func orderingKey(name string) (events.KeyFunc, error) {
+	switch name {
+	case "", "none":
+		return nil, nil
+	case "user_id":
+		return events.KeyByUserID, nil
+	}
+	return nil, fmt.Errorf("unknown LISTENER_ORDER_BY %q", name)
+}
+
+func envString(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func envBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net/http"
+	"time"
+)
+
+// serveMetrics exposes the expvar counters (event_handlers, event_consumer)
+// on addr until ctx is cancelled.
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Println("Serving metrics on", addr+"/debug/vars")
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Println("Metrics server failed:", err)
+	}
+}
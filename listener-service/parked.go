@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"events"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// runParked implements the "parked" subcommand used to inspect and replay
+// messages that ended up in the parking queue:
+//
+//	listenerApp parked list   [-queue listener.logs] [-limit 10]
+//	listenerApp parked replay [-queue listener.logs] [-limit 10]
+func runParked(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: parked <list|replay> [-queue name] [-limit n]")
+		os.Exit(2)
+	}
+
+	command := args[0]
+	flags := flag.NewFlagSet("parked "+command, flag.ExitOnError)
+	queue := flags.String("queue", envString("LISTENER_QUEUE", "listener.logs"), "work queue whose parking queue to use")
+	limit := flags.Int("limit", 10, "maximum number of messages to process")
+	flags.Parse(args[1:])
+
+	rabbitConn, err := connect()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rabbitConn.Close()
+
+	switch command {
+	case "list":
+		parked, err := events.ListParked(rabbitConn, *queue, *limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		encoder.Encode(parked)
+
+	case "replay":
+		replayed, err := events.ReplayParked(rabbitConn, *queue, *limit)
+		if err != nil {
+			log.Fatalf("replayed %d message(s) before failing: %v", replayed, err)
+		}
+		fmt.Printf("Replayed %d message(s) to %s\n", replayed, *queue)
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown parked command %q\n", command)
+		os.Exit(2)
+	}
+}